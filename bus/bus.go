@@ -1,25 +1,44 @@
 package bus
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/jsmorph/evpat/pat"
 )
 
 type Msg struct {
-	Type    string      `json:"type,omitempty"`
+	Type string `json:"type,omitempty"`
+
+	// Payload can be any value that encoding/json can marshal.
+	// Filters see it via reflection (see pat.MatchesValue), so
+	// it's never round-tripped through JSON.  A json.RawMessage
+	// isn't decoded any more than filtering requires.
 	Payload interface{} `json:"payload"`
-	Id      string      `json:"id,omitempty"`
+
+	Id string `json:"id,omitempty"`
+}
+
+// filter reports whether the given message matches the given filter.
+//
+// Errors are considered non-matches.
+func filter(c pat.Constraint, msg *Msg) bool {
+	ok, err := pat.MatchesValue(c, msg)
+	return ok && err == nil
 }
 
 type Consumer struct {
 	Query    *Query
 	Outgoing chan []Msg
+
+	// id is the Consumer's pattern id in the Bus's pat.Machine.
+	id string
 }
 
 type Query struct {
@@ -27,6 +46,13 @@ type Query struct {
 	Limit  int
 	Filter pat.Constraint
 
+	// Projection, if not nil, replaces each delivered message's
+	// Payload with the output of the Transformer on that Payload.
+	// The output is a json.RawMessage, or a string if the
+	// Transformer's template is plain text.  A message that the
+	// Transformer can't handle is logged and isn't delivered.
+	Projection *pat.Transformer
+
 	// From, To string
 }
 
@@ -74,6 +100,9 @@ type Bus struct {
 	RemConsumer chan *Consumer
 
 	ws *WorkersPool
+
+	// machine holds the filters of all current consumers.
+	machine *pat.Machine
 }
 
 func (cfg *Cfg) New() *Bus {
@@ -83,6 +112,7 @@ func (cfg *Cfg) New() *Bus {
 		AddConsumer: make(chan *Consumer),
 		RemConsumer: make(chan *Consumer),
 		ws:          NewWorkersPool(cfg.NumWorkers),
+		machine:     pat.NewMachine(),
 	}
 }
 
@@ -91,7 +121,8 @@ func NewBus() *Bus {
 }
 
 func (b *Bus) work(ctx context.Context, f func(context.Context) error) error {
-	wsctx, _ := context.WithTimeout(ctx, b.WorkersTimeout)
+	wsctx, cancel := context.WithTimeout(ctx, b.WorkersTimeout)
+	defer cancel()
 	i, err := b.ws.Get(wsctx)
 	if err != nil {
 		return err
@@ -112,7 +143,10 @@ var (
 
 func (b *Bus) Run(ctx context.Context) error {
 
-	clients := make(map[*Consumer]bool)
+	var (
+		clients = make(map[string]*Consumer)
+		n       = 0
+	)
 
 	for {
 		select {
@@ -124,60 +158,146 @@ func (b *Bus) Run(ctx context.Context) error {
 					return err
 				}
 			}
+			cs := make(map[string]*Consumer, len(clients))
+			for id, c := range clients {
+				cs[id] = c
+			}
 			f := func(ctx context.Context) error {
-				for c := range clients {
-					b.forward(ctx, c, msgs)
-				}
-				return nil
+				return b.dispatch(ctx, cs, msgs)
 			}
 			b.work(ctx, f)
 		case c := <-b.AddConsumer:
-			if 0 < b.MaxReplay && b.MaxReplay < c.Query.Limit {
-				c.Query.Limit = b.MaxReplay
+			// Copy the Query, which might be shared (like
+			// DefaultQuery), before changing it.
+			q := DefaultQuery
+			if c.Query != nil {
+				q = c.Query
+			}
+			qc := *q
+			if 0 < b.MaxReplay && b.MaxReplay < qc.Limit {
+				qc.Limit = b.MaxReplay
 			}
-			clients[c] = true
+			c.Query = &qc
+			n++
+			c.id = strconv.Itoa(n)
+			clients[c.id] = c
+			b.machine.Add(c.id, c.Query.Filter)
 			f := func(ctx context.Context) error {
 				return b.Replay(ctx, c)
 			}
 			b.work(ctx, f)
 		case c := <-b.RemConsumer:
-			delete(clients, c)
+			delete(clients, c.id)
+			b.machine.Remove(c.id)
 		}
 	}
+}
+
+// dispatch uses the Bus's pat.Machine to find the consumers for each
+// message in one pass, and then it delivers those messages.
+//
+// If the Machine can't handle a message (say, because some value
+// that a filter mentions can't be marshaled), then each consumer's
+// filter is checked on its own so that the message still reaches the
+// consumers whose filters don't need that value.
+func (b *Bus) dispatch(ctx context.Context, cs map[string]*Consumer, msgs []Msg) error {
+	filtered := make(map[string][]Msg, len(cs))
+	for i, msg := range msgs {
+		ids, err := b.machine.MatchesValue(&msgs[i])
+		if err != nil {
+			log.Printf("Bus.dispatch message %q: %v", msg.Id, err)
+			for id, c := range cs {
+				if filter(c.Query.Filter, &msgs[i]) {
+					filtered[id] = append(filtered[id], msg)
+				}
+			}
+			continue
+		}
+		for _, id := range ids {
+			filtered[id] = append(filtered[id], msg)
+		}
+	}
+
+	for id, c := range cs {
+		msgs := filtered[id]
+		if msgs == nil {
+			msgs = []Msg{}
+		}
+		b.deliver(ctx, c, msgs)
+	}
+
 	return nil
 }
 
 func (b *Bus) forward(ctx context.Context, c *Consumer, msgs []Msg) error {
 
-	var filtered []Msg
-	if c.Query == nil {
-		c.Query = DefaultQuery
+	q := c.Query
+	if q == nil {
+		q = DefaultQuery
+	}
+	filtered := make([]Msg, 0, len(msgs))
+	for i := range msgs {
+		if filter(q.Filter, &msgs[i]) {
+			filtered = append(filtered, msgs[i])
+		}
 	}
-	filtered = make([]Msg, 0, len(msgs))
+
+	return b.deliver(ctx, c, filtered)
+}
+
+// project applies the given Transformer to each message's Payload.
+//
+// A message that the Transformer can't handle is logged and dropped
+// rather than delivered with fields that the consumer didn't select.
+func project(t *pat.Transformer, msgs []Msg) []Msg {
+	acc := make([]Msg, 0, len(msgs))
 	for _, msg := range msgs {
-		x := Canonicalize(msg)
-		if ok, _ := c.Query.Filter.Matches(x); ok {
-			filtered = append(filtered, msg)
+		js, err := t.Transform(msg.Payload)
+		if err != nil {
+			log.Printf("Bus.project dropping message %q: %v", msg.Id, err)
+			continue
 		}
+		if t.Text() {
+			msg.Payload = string(js)
+		} else {
+			msg.Payload = json.RawMessage(js)
+		}
+		acc = append(acc, msg)
 	}
+	return acc
+}
 
+func (b *Bus) deliver(ctx context.Context, c *Consumer, msgs []Msg) error {
+	if c.Query != nil && c.Query.Projection != nil {
+		msgs = project(c.Query.Projection, msgs)
+	}
 	select {
 	case <-ctx.Done():
 		return Canceled
 	case <-time.NewTimer(b.ConsumerTimeout).C:
 		return Timeout
-	case c.Outgoing <- filtered:
+	case c.Outgoing <- msgs:
 		return nil
 	}
 }
 
+// Canonicalize returns the generic (JSON-decoded) form of the given
+// value.  Numbers are json.Numbers.
+//
+// Filtering no longer needs this function (see pat.MatchesValue),
+// but it stays exported for callers that want to match or inspect
+// the generic form of a message themselves.
 func Canonicalize(x interface{}) interface{} {
 	js, err := json.Marshal(&x)
 	if err != nil {
 		return x
 	}
-	var y interface{}
-	if err = json.Unmarshal(js, &y); err != nil {
+	var (
+		y interface{}
+		d = json.NewDecoder(bytes.NewReader(js))
+	)
+	d.UseNumber()
+	if err = d.Decode(&y); err != nil {
 		return x
 	}
 	return y
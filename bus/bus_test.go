@@ -0,0 +1,189 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jsmorph/evpat/pat"
+)
+
+func TestFilter(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		b           = NewBus()
+	)
+	defer cancel()
+
+	go b.Run(ctx)
+
+	var x interface{}
+	if err := json.Unmarshal([]byte(`{"payload":{"want":["tacos"]}}`), &x); err != nil {
+		t.Fatal(err)
+	}
+	f, err := pat.ParsePattern(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Consumer{
+		Outgoing: make(chan []Msg, 1),
+		Query: &Query{
+			Filter: f,
+		},
+	}
+	b.AddConsumer <- c
+
+	b.Incoming <- []Msg{
+		{Id: "1", Payload: json.RawMessage(`{"want":"queso","junk":[1,{"x":2}]}`)},
+		{Id: "2", Payload: json.RawMessage(`{"want":"tacos","junk":[1,{"x":2}]}`)},
+		{Id: "3", Payload: map[string]interface{}{"want": "tacos"}},
+		{Id: "4", Payload: struct {
+			Want string `json:"want"`
+		}{"tacos"}},
+		{Id: "5", Payload: struct {
+			Want string
+		}{"tacos"}},
+	}
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	case msgs := <-c.Outgoing:
+		if len(msgs) != 3 || msgs[0].Id != "2" || msgs[1].Id != "3" || msgs[2].Id != "4" {
+			t.Fatal(pat.JSON(msgs))
+		}
+	}
+}
+
+// TestDispatchError checks that a message that a filter can't handle
+// still reaches the consumers whose filters don't need the bad value.
+func TestDispatchError(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		b           = NewBus()
+	)
+	defer cancel()
+
+	go b.Run(ctx)
+
+	var x interface{}
+	if err := json.Unmarshal([]byte(`{"payload":{"want":["tacos"]}}`), &x); err != nil {
+		t.Fatal(err)
+	}
+	f, err := pat.ParsePattern(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		all = &Consumer{
+			Outgoing: make(chan []Msg, 1),
+			Query: &Query{
+				Filter: pat.Pass,
+			},
+		}
+		some = &Consumer{
+			Outgoing: make(chan []Msg, 1),
+			Query: &Query{
+				Filter: f,
+			},
+		}
+	)
+	b.AddConsumer <- all
+	b.AddConsumer <- some
+
+	b.Incoming <- []Msg{
+		{Id: "1", Payload: map[string]interface{}{"want": func() {}}},
+		{Id: "2", Payload: map[string]interface{}{"want": "tacos"}},
+	}
+
+	for c, want := range map[*Consumer]string{all: "12", some: "2"} {
+		select {
+		case <-ctx.Done():
+			t.Fatal("timeout")
+		case msgs := <-c.Outgoing:
+			got := ""
+			for _, msg := range msgs {
+				got += msg.Id
+			}
+			if got != want {
+				t.Fatalf("want %s, got %s", want, got)
+			}
+		}
+	}
+}
+
+func TestProjection(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		b           = NewBus()
+	)
+	defer cancel()
+
+	go b.Run(ctx)
+
+	p, err := pat.NewProjection([]string{"detail.orderId", "time"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Consumer{
+		Outgoing: make(chan []Msg, 1),
+		Query: &Query{
+			Filter:     pat.Pass,
+			Projection: p,
+		},
+	}
+	b.AddConsumer <- c
+
+	b.Incoming <- []Msg{
+		{Type: "order", Id: "1", Payload: json.RawMessage(`{"time":"t1","detail":{"orderId":"o-1","items":[1,2,3]}}`)},
+		{Type: "order", Id: "2", Payload: map[string]interface{}{"detail": "none"}},
+	}
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	case msgs := <-c.Outgoing:
+		got := pat.JSON(msgs)
+		want := `[{"type":"order","payload":{"detail.orderId":"o-1","time":"t1"},"id":"1"},{"type":"order","payload":{},"id":"2"}]` + "\n"
+		if got != want {
+			t.Fatalf("%s != %s", got, want)
+		}
+	}
+}
+
+func TestDefaultQueryUnchanged(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		cfg         = *DefaultCfg
+	)
+	defer cancel()
+	cfg.MaxReplay = 1
+	b := cfg.New()
+
+	go b.Run(ctx)
+
+	limit := DefaultQuery.Limit
+	shared := &Query{
+		Limit:  5,
+		Filter: pat.Pass,
+	}
+	c := &Consumer{
+		Outgoing: make(chan []Msg, 1),
+	}
+	d := &Consumer{
+		Outgoing: make(chan []Msg, 1),
+		Query:    shared,
+	}
+	b.AddConsumer <- c
+	b.AddConsumer <- d
+	b.RemConsumer <- c
+	b.RemConsumer <- d
+
+	if DefaultQuery.Limit != limit || shared.Limit != 5 {
+		t.Fatal(DefaultQuery.Limit, shared.Limit)
+	}
+	if c.Query.Limit != 1 || d.Query.Limit != 1 {
+		t.Fatal(c.Query.Limit, d.Query.Limit)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"log"
 	"sync"
+
+	"github.com/jsmorph/evpat/pat"
 )
 
 type Ring struct {
@@ -120,7 +122,7 @@ func (r *Ring) Read(ctx context.Context, q *Query) (chan []Msg, error) {
 
 LOOP:
 	for _, msg := range msgs {
-		if pass, err := q.Filter.Matches(Canonicalize(msg)); err != nil {
+		if pass, err := pat.MatchesValue(q.Filter, msg); err != nil {
 			log.Printf("Ring.Read debug error %s", err)
 			return nil, err
 		} else if pass {
@@ -29,10 +29,11 @@ func run() error {
 		redisPort    = flag.String("redis", "localhost:6379", "Redis host:port")
 		sessionLimit = flag.Int("session-limit", 1000, "Max events per session")
 		maxReplay    = flag.Int("max-replay", 100, "max messages to replay for a client")
+		debug        = flag.Bool("debug", false, "serve /debug/explain")
 
 		ctx, cancel = context.WithCancel(context.Background())
-		b           = bus.NewBus(10, time.Second, time.Second) // ToDo
-		db          = bus.NewRing(100)                         // ToDo
+		b           = bus.NewBus()
+		db          = bus.NewRing(100) // ToDo
 		s           = sse.NewSSE(b)
 	)
 	defer cancel()
@@ -62,8 +63,11 @@ func run() error {
 					log.Fatal(err)
 				}
 
-				var x interface{}
-				if err := json.Unmarshal([]byte(m.Payload), &x); err != nil {
+				// Payloads are passed along without being
+				// decoded.  The bus only decodes what its
+				// filters need.
+				var x interface{} = json.RawMessage(m.Payload)
+				if !json.Valid([]byte(m.Payload)) {
 					x = map[string]interface{}{
 						"go": fmt.Sprintf("%#v", m.Payload),
 					}
@@ -87,5 +91,11 @@ func run() error {
 		s.Handle(ctx, w, r)
 	}
 
-	return http.ListenAndServe(*httpPort, http.HandlerFunc(h))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", h)
+	if *debug {
+		mux.HandleFunc("/debug/explain", s.HandleExplain)
+	}
+
+	return http.ListenAndServe(*httpPort, mux)
 }
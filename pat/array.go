@@ -0,0 +1,103 @@
+package pat
+
+// The array operators below are extensions to EventBridge patterns,
+// and a Cfg only parses them when its Arrays is true.  Unlike other
+// constraints in an array of constraints, they apply to the whole
+// value rather than to each element of an array.
+//
+//	{"resources":[{"element":{"index":0,"pattern":[{"prefix":"arn:aws:s3:"}]}}]}
+//	{"tags":[{"length":[">=",2]}]}
+
+// Element matches an array whose element at the given index matches
+// the pattern.  A negative index counts from the end of the array, so
+// -1 is the last element.  A value that isn't an array doesn't match.
+type Element struct {
+	Index   int
+	Pattern Constraint
+}
+
+func (c *Element) wholeValue() {}
+
+func (c *Element) Matches(x interface{}) (bool, error) {
+	xs, is := x.([]interface{})
+	if !is {
+		return false, nil
+	}
+	i := c.Index
+	if i < 0 {
+		i += len(xs)
+	}
+	if i < 0 || len(xs) <= i {
+		return false, nil
+	}
+	return c.Pattern.Matches(xs[i])
+}
+
+// Length matches an array with a length in the given interval.  A
+// value that isn't an array doesn't match.
+type Length struct {
+	Numeric *Numeric
+}
+
+func (c *Length) wholeValue() {}
+
+func (c *Length) Matches(x interface{}) (bool, error) {
+	xs, is := x.([]interface{})
+	if !is {
+		return false, nil
+	}
+	return c.Numeric.Matches(len(xs))
+}
+
+// parseArrayOperator parses an "element" or "length" operator if the
+// Cfg allows them.  Otherwise it returns nil and no error.
+func (cfg *Cfg) parseArrayOperator(m map[string]interface{}, path string) (Constraint, error) {
+	if !cfg.Arrays || cfg.Strict {
+		return nil, nil
+	}
+
+	if y, have := m["element"]; have {
+		path := pathField(path, "element")
+		vv, is := y.(map[string]interface{})
+		if !is {
+			return nil, parseError(path, CodeType, y, "bad element '%#v'", y)
+		}
+		for k := range vv {
+			if k != "index" && k != "pattern" {
+				return nil, parseError(pathField(path, k), CodeOperator, vv[k], "unknown element property '%s'", k)
+			}
+		}
+		n, ok := toNumber(vv["index"])
+		if !ok || !n.rat().IsInt() || !n.rat().Num().IsInt64() {
+			return nil, parseError(pathField(path, "index"), CodeType, vv["index"], "bad element index '%#v'", vv["index"])
+		}
+		i := n.rat().Num().Int64()
+		if i != int64(int(i)) {
+			return nil, parseError(pathField(path, "index"), CodeValue, vv["index"], "element index %d out of range", i)
+		}
+		p, is := vv["pattern"].([]interface{})
+		if !is {
+			return nil, parseError(pathField(path, "pattern"), CodeType, vv["pattern"], "bad element pattern '%#v'", vv["pattern"])
+		}
+		c, err := cfg.parsePattern(p, pathField(path, "pattern"))
+		if err != nil {
+			return nil, err
+		}
+		return &Element{
+			Index:   int(i),
+			Pattern: c,
+		}, nil
+	}
+
+	if y, have := m["length"]; have {
+		n, err := cfg.parseNumeric(y, pathField(path, "length"))
+		if err != nil {
+			return nil, err
+		}
+		return &Length{
+			Numeric: n,
+		}, nil
+	}
+
+	return nil, nil
+}
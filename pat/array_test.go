@@ -0,0 +1,53 @@
+package pat
+
+import (
+	"testing"
+)
+
+func TestArrayOperatorsCfg(t *testing.T) {
+	for _, js := range []string{
+		`{"a":[{"element":{"index":0,"pattern":["x"]}}]}`,
+		`{"a":[{"length":[">",1]}]}`,
+	} {
+		t.Run(js, func(t *testing.T) {
+			c, err := (&Cfg{}).ParsePattern(P(js))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, is := c.(Map)["a"].(Constraints)[0].(Map); !is {
+				t.Fatalf("%T", c.(Map)["a"].(Constraints)[0])
+			}
+			if _, err := (&Cfg{Arrays: true, Strict: true}).ParsePattern(P(js)); err == nil {
+				t.Fatal("strict mode should reject array operators")
+			}
+		})
+	}
+
+	for _, js := range []string{
+		`{"a":[{"element":{"index":0.5,"pattern":["x"]}}]}`,
+		`{"a":[{"element":{"index":0,"pattern":"x"}}]}`,
+		`{"a":[{"element":{"index":0,"pattern":["x"],"other":1}}]}`,
+		`{"a":[{"element":[0,["x"]]}]}`,
+		`{"a":[{"length":[">",3,"<",2]}]}`,
+	} {
+		t.Run(js, func(t *testing.T) {
+			if _, err := (&Cfg{Arrays: true}).ParsePattern(P(js)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestGenerateArrays(t *testing.T) {
+	c, err := (&Cfg{Arrays: true}).ParsePattern(P(`{"r":[{"element":{"index":1,"pattern":[{"prefix":"s3"}]}}],"tags":[{"length":[">=",2]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := Generate(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(x.Matching) != 1 || len(x.NearMisses) != 2 {
+		t.Fatal(JSON(x))
+	}
+}
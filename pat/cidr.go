@@ -0,0 +1,43 @@
+package pat
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// CIDR matches strings that are IPv4 or IPv6 addresses in the given
+// subnet.
+//
+// Use NewCIDR to make one.
+type CIDR struct {
+	Value string
+
+	prefix netip.Prefix
+}
+
+// NewCIDR parses the given subnet (like "10.0.0.0/24").
+func NewCIDR(s string) (*CIDR, error) {
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return nil, fmt.Errorf("bad cidr '%s': %w", s, err)
+	}
+	return &CIDR{
+		Value:  s,
+		prefix: p.Masked(),
+	}, nil
+}
+
+func (c *CIDR) Matches(x interface{}) (bool, error) {
+	if !c.prefix.IsValid() {
+		return false, fmt.Errorf("unparsed cidr '%s'", c.Value)
+	}
+	s, is := x.(string)
+	if !is {
+		return false, nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return false, nil
+	}
+	return c.prefix.Contains(a.Unmap()), nil
+}
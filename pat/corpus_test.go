@@ -0,0 +1,119 @@
+package pat
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// record makes TestWithAWS write the recorded corpus:
+//
+//	AWS_PROFILE=... go test ./pat -run TestWithAWS -record
+//
+// The corpus is only written if every call to EventBridge got a
+// verdict.
+var record = flag.Bool("record", false, "record EventBridge verdicts in "+corpusFile)
+
+const corpusFile = "testdata/aws.json"
+
+// Corpus is a set of verdicts from EventBridge's TestEventPattern,
+// which TestRecorded replays offline.
+type Corpus struct {
+	// Source describes where the verdicts came from.
+	Source string `json:"source"`
+
+	// Recorded is when the verdicts were recorded (RFC3339).
+	Recorded string `json:"recorded,omitempty"`
+
+	// Region is the AWS region that gave the verdicts.
+	Region string `json:"region,omitempty"`
+
+	Cases []Verdict `json:"cases"`
+}
+
+// Verdict is EventBridge's verdict for a pattern and an event.
+type Verdict struct {
+	Pat interface{} `json:"pat"`
+
+	// Msg is the event exactly as it was sent.
+	Msg interface{} `json:"msg"`
+
+	Matches bool `json:"matches"`
+
+	// Error indicates that EventBridge rejected the pattern (or
+	// the event), and Message is its error message.
+	Error   bool   `json:"error,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// readCorpus returns the recorded corpus, or nil if there isn't one.
+func readCorpus(t testing.TB) *Corpus {
+	bs, err := ioutil.ReadFile(corpusFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		c Corpus
+		d = json.NewDecoder(bytes.NewReader(bs))
+	)
+	d.UseNumber()
+	if err := d.Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+
+	return &c
+}
+
+func writeCorpus(t testing.TB, c *Corpus) {
+	js, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(corpusFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(corpusFile, append(js, '\n'), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRecorded replays the recorded EventBridge verdicts and reports
+// each case where this package disagrees.
+func TestRecorded(t *testing.T) {
+	c := readCorpus(t)
+	if c == nil {
+		t.Skip("no recorded corpus, so nothing was checked against EventBridge (see TestWithAWS -record)")
+	}
+	t.Logf("%d cases from %s", len(c.Cases), c.Source)
+
+	for _, v := range c.Cases {
+		v := v
+		t.Run(JSON(v.Pat)+JSON(v.Msg), func(t *testing.T) {
+			p, err := ParsePattern(v.Pat)
+			if v.Error {
+				if err == nil {
+					t.Errorf("divergence: EventBridge rejected the pattern (%s) but pat accepted it", v.Message)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("divergence: EventBridge accepted the pattern but pat didn't: %s", err)
+			}
+			got, err := p.Matches(v.Msg)
+			if err != nil {
+				t.Fatalf("divergence: EventBridge matched without error but pat returned %s", err)
+			}
+			if got != v.Matches {
+				t.Errorf("divergence: EventBridge said %v but pat said %v", v.Matches, got)
+			}
+		})
+	}
+}
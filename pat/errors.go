@@ -0,0 +1,99 @@
+package pat
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Codes for ParseErrors.
+const (
+	// CodeType means that a value has the wrong JSON type (like a
+	// "prefix" that isn't a string).
+	CodeType = "type"
+
+	// CodeValue means that a value has the right type but is
+	// otherwise bad (like an unparsable CIDR block).
+	CodeValue = "value"
+
+	// CodeOperator means an unknown operator or numeric relation.
+	CodeOperator = "operator"
+
+	// CodeRange means a numeric range that's contradictory or
+	// empty.
+	CodeRange = "range"
+
+	// CodeStrict means a pattern that Cfg.Strict rejects.
+	CodeStrict = "strict"
+
+	// CodeLimit means a pattern that exceeds one of the Cfg's
+	// limits.  The ParseError wraps a *LimitError.
+	CodeLimit = "limit"
+)
+
+// ParseError is the error that ParsePattern returns for a bad
+// pattern.
+type ParseError struct {
+	// Path is the location of the problem in the pattern, like
+	// "detail.price[0].numeric[2]".  The root is "".  Names that
+	// aren't simple are quoted as in Path.String (like
+	// "detail['a.b']"), so ParsePath parses the Path.
+	Path string
+
+	// Value is the offending part of the pattern.
+	Value interface{}
+
+	// Code is one of the Code constants.
+	Code string
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s at %s", e.Err, e.Path)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// MarshalJSON renders the error as an object with "code", "path",
+// "value", and "message" properties.
+func (e *ParseError) MarshalJSON() ([]byte, error) {
+	return marshal(map[string]interface{}{
+		"code":    e.Code,
+		"path":    e.Path,
+		"value":   e.Value,
+		"message": e.Error(),
+	})
+}
+
+// parseError makes a *ParseError with a formatted underlying error.
+func parseError(path, code string, x interface{}, format string, args ...interface{}) *ParseError {
+	return &ParseError{
+		Path:  path,
+		Value: x,
+		Code:  code,
+		Err:   fmt.Errorf(format, args...),
+	}
+}
+
+// pathField extends a path (as in a ParseError) with an object
+// property.  A name that isn't simple is quoted as in Path.String.
+func pathField(path, k string) string {
+	switch {
+	case !simpleName(k):
+		return path + quoteName(k)
+	case path == "":
+		return k
+	}
+	return path + "." + k
+}
+
+// pathIndex extends a path with an array index.
+func pathIndex(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
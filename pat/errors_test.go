@@ -0,0 +1,73 @@
+package pat
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseError(t *testing.T) {
+	try := func(js, path, code string) {
+		t.Run(js, func(t *testing.T) {
+			_, err := (&Cfg{Strict: true, MaxLeaves: 10}).ParsePattern(P(js))
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("want a ParseError, got %v", err)
+			}
+			if pe.Path != path {
+				t.Fatalf("want path %q, got %q", path, pe.Path)
+			}
+			if pe.Code != code {
+				t.Fatalf("want code %q, got %q", code, pe.Code)
+			}
+		})
+	}
+
+	try(`{"detail":{"price":[{"numeric":[">",0,"<"]}]}}`, "detail.price[0].numeric", CodeValue)
+	try(`{"detail":{"price":[{"numeric":[">",0,"<","x"]}]}}`, "detail.price[0].numeric[3]", CodeType)
+	try(`{"detail":{"price":[{"numeric":[">",0,"!=",3]}]}}`, "detail.price[0].numeric[2]", CodeOperator)
+	try(`{"detail":{"price":[{"numeric":[">",10,"<",5]}]}}`, "detail.price[0].numeric", CodeRange)
+	try(`{"detail":{"price":[1,{"numeric":[">",1,">",2]}]}}`, "detail.price[1].numeric[2]", CodeRange)
+	try(`{"a":[{"prefix":7}]}`, "a[0].prefix", CodeType)
+	try(`{"a.b":{"c[0]":[{"prefix":7}]}}`, "['a.b']['c[0]'][0].prefix", CodeType)
+	try(`{"a":[{"anything-but":{"tacos":"x"}}]}`, "a[0].anything-but.tacos", CodeOperator)
+	try(`{"a":[{"anything-but":[]}]}`, "a[0].anything-but", CodeValue)
+	try(`{"a":[{"anything-but":["x",{"y":1}]}]}`, "a[0].anything-but[1]", CodeType)
+	try(`{"a":[{"anything-but":[[1]]}]}`, "a[0].anything-but[0]", CodeType)
+	try(`{"a":[{"cidr":"10.0.0.0/99"}]}`, "a[0].cidr", CodeValue)
+	try(`{"a":[{"wildcard":"**"}]}`, "a[0].wildcard", CodeValue)
+	try(`{"a":[{"prefix":"x","numeric":[">",1]}]}`, "a[0]", CodeOperator)
+	try(`{"a":[{"prefix":"x","bogus":1}]}`, "a[0]", CodeOperator)
+	try(`{"$or":[{"a":["x"]},"b"]}`, "$or[1]", CodeType)
+	try(`{"a":"x"}`, "a", CodeStrict)
+	try(`"x"`, "", CodeStrict)
+	try(`{"a":["x","x","x","x","x","x","x","x","x","x","x"]}`, "a[10]", CodeLimit)
+
+	// The first offending key (in order) is reported.
+	for i := 0; i < 10; i++ {
+		try(`{"c":[{"prefix":1}],"b":[{"suffix":1}],"d":"x"}`, "b[0].suffix", CodeType)
+		try(`{"c":"x","b":"y","a":["z"]}`, "b", CodeStrict)
+
+		_, err := (&Cfg{MaxStringLength: 3}).ParsePattern(P(`{"c":["xxxx"],"b":["xxxx"],"a":["x"]}`))
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Path != "b[0]" {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := (&Cfg{MaxDepth: 2}).ParsePattern(P(`{"a":{"b":["x"]}}`))
+	var le *LimitError
+	if !errors.As(err, &le) || le.Limit != "MaxDepth" {
+		t.Fatal(err)
+	}
+
+	var pe *ParseError
+	errors.As(err, &pe)
+	var x map[string]interface{}
+	if err := json.Unmarshal([]byte(JSON(pe)), &x); err != nil {
+		t.Fatal(err)
+	}
+	if x["code"] != CodeLimit || x["path"] != "a.b" || x["message"] != pe.Error() {
+		t.Fatal(JSON(pe))
+	}
+}
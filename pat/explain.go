@@ -0,0 +1,129 @@
+package pat
+
+import (
+	"sort"
+)
+
+// Explanation reports why a message did or did not match a pattern.
+type Explanation struct {
+	Matched bool   `json:"matched"`
+	Steps   []Step `json:"steps"`
+}
+
+// Step is a check that Explain performed at a path in the message.
+type Step struct {
+	// Path is the path (like "detail.price") of the value that was
+	// checked.  See ParseError.
+	Path string `json:"path"`
+
+	// Constraint is the pattern that was applied.
+	Constraint interface{} `json:"constraint"`
+
+	// Value is the value found at the Path.  It's null when the
+	// value is Missing (or is itself null) and for a Step with
+	// nested Steps.
+	Value interface{} `json:"value"`
+
+	// Missing reports that no value was found at the Path.
+	Missing bool `json:"missing,omitempty"`
+
+	Matched bool   `json:"matched"`
+	Error   string `json:"error,omitempty"`
+
+	// Steps are the checks performed for a nested Map or for the
+	// disjuncts of an Or.
+	Steps []Step `json:"steps,omitempty"`
+}
+
+// Explain matches the given message against the given pattern and
+// returns a trace of the checks.
+//
+// Every key of a Map is checked (even after one fails) so that the
+// trace reports all the problems.  Use pat.JSON to render the result.
+func Explain(c Constraint, msg interface{}) *Explanation {
+	s := explain("", c, msg, true)
+	if s.Steps == nil {
+		s.Steps = []Step{}
+	}
+	return &Explanation{
+		Matched: s.Matched,
+		Steps:   s.Steps,
+	}
+}
+
+func explain(path string, c interface{}, x interface{}, have bool) Step {
+	s := Step{
+		Path:       path,
+		Constraint: c,
+		Value:      x,
+		Missing:    !have,
+	}
+	if !have {
+		s.Value = nil
+	}
+
+	switch vv := c.(type) {
+	case Map:
+		m, is := x.(map[string]interface{})
+		if !is {
+			if path == "" || len(vv) == 0 {
+				break
+			}
+			// See Map.matches.
+			m = map[string]interface{}{}
+		}
+		ks := make([]string, 0, len(vv))
+		for k := range vv {
+			ks = append(ks, k)
+		}
+		sort.Strings(ks)
+
+		// The nested steps say everything about the values.
+		s.Value = nil
+		s.Matched = true
+		for _, k := range ks {
+			var sub Step
+			if o, is := vv[k].(Or); is {
+				sub = explainOr(path, o, m)
+			} else {
+				y, have := m[k]
+				if !have {
+					y = Missing
+				}
+				sub = explain(pathField(path, k), vv[k], y, have)
+				ok, err := vv.matches(m, k, vv[k])
+				sub.Matched = ok
+				if err != nil {
+					sub.Error = err.Error()
+				}
+			}
+			s.Steps = append(s.Steps, sub)
+			if !sub.Matched {
+				s.Matched = false
+			}
+		}
+		return s
+	}
+
+	ok, err := Matches(c, x)
+	s.Matched = ok
+	if err != nil {
+		s.Error = err.Error()
+	}
+	return s
+}
+
+func explainOr(path string, o Or, m map[string]interface{}) Step {
+	s := Step{
+		Path:       path,
+		Constraint: o,
+	}
+	for _, d := range o {
+		sub := explain(path, d, m, true)
+		s.Steps = append(s.Steps, sub)
+		if sub.Matched {
+			s.Matched = true
+		}
+	}
+	return s
+}
@@ -0,0 +1,99 @@
+package pat
+
+import (
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	c, err := ParsePattern(P(`{"source":["shop"],"detail":{"total":[{"numeric":[">",10]}],"coupon":[{"exists":false}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := Explain(c, P(`{"source":"shop","detail":{"total":5}}`))
+	if e.Matched {
+		t.Fatal(JSON(e))
+	}
+
+	if len(e.Steps) != 2 {
+		t.Fatal(JSON(e))
+	}
+
+	detail := e.Steps[0]
+	if detail.Path != "detail" || detail.Matched || len(detail.Steps) != 2 {
+		t.Fatal(JSON(detail))
+	}
+	if s := detail.Steps[0]; s.Path != "detail.coupon" || !s.Matched || !s.Missing {
+		t.Fatal(JSON(s))
+	}
+	if s := detail.Steps[1]; s.Path != "detail.total" || s.Matched || s.Value != 5.0 {
+		t.Fatal(JSON(s))
+	}
+	if s := e.Steps[1]; s.Path != "source" || !s.Matched || s.Value != "shop" {
+		t.Fatal(JSON(s))
+	}
+
+	// A null value is reported, and Missing tells it from a
+	// missing one.
+	e = Explain(c, P(`{"source":null,"detail":{"total":5}}`))
+	if got, want := JSON(e.Steps[1]), `{"path":"source","constraint":["shop"],"value":null,"matched":false}`+"\n"; got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+
+	e = Explain(c, P(`{"source":"shop","detail":{"total":50}}`))
+	if !e.Matched {
+		t.Fatal(JSON(e))
+	}
+}
+
+func TestExplainOr(t *testing.T) {
+	c, err := ParsePattern(P(`{"$or":[{"a":[1]},{"b":[2]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := Explain(c, P(`{"b":2}`))
+	if !e.Matched || len(e.Steps) != 1 {
+		t.Fatal(JSON(e))
+	}
+	or := e.Steps[0]
+	if len(or.Steps) != 2 || or.Steps[0].Matched || !or.Steps[1].Matched {
+		t.Fatal(JSON(e))
+	}
+	if s := or.Steps[0].Steps[0]; s.Path != "a" || !s.Missing {
+		t.Fatal(JSON(s))
+	}
+}
+
+func TestExplainPath(t *testing.T) {
+	c, err := ParsePattern(P(`{"detail":{"a.b":["x"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Explain(c, P(`{"detail":{"a.b":"x"}}`))
+	if s := e.Steps[0].Steps[0]; s.Path != "detail['a.b']" || !s.Matched {
+		t.Fatal(JSON(e))
+	}
+	if _, err := ParsePath(e.Steps[0].Steps[0].Path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestExplainAgrees checks that Explain agrees with Matches for the
+// cases in tests.json.
+func TestExplainAgrees(t *testing.T) {
+	for _, tc := range readCases(t) {
+		if tc.Error {
+			continue
+		}
+		t.Run(JSON(tc), func(t *testing.T) {
+			c, err := tc.cfg().ParsePattern(tc.Pat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e := Explain(c, tc.Msg); e.Matched != tc.Matches {
+				t.Fatal(JSON(e))
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package pat
+
+import (
+	"testing"
+)
+
+// FuzzMatches fuzzes patterns and events together.  For each pattern
+// that parses, it checks that matching doesn't panic, that results
+// are deterministic (and so don't depend on map iteration order),
+// that MatchesJSON, MatchesValue, and a Machine agree with Matches,
+// and that the pattern's serialization parses into a pattern that
+// matches the same event.
+//
+//	go test ./pat -run XXX -fuzz FuzzMatches
+func FuzzMatches(f *testing.F) {
+	for _, tc := range readCases(f) {
+		f.Add(JSON(tc.Pat), JSON(tc.Msg), tc.Arrays)
+	}
+
+	f.Fuzz(func(t *testing.T, pat, msg string, arrays bool) {
+		cfg := &Cfg{
+			Arrays: arrays,
+		}
+		x, err := decodeSelection([]byte(pat), everything)
+		if err != nil {
+			return
+		}
+		c, err := cfg.ParsePattern(x)
+		if err != nil {
+			return
+		}
+		m, err := decodeSelection([]byte(msg), everything)
+		if err != nil {
+			return
+		}
+
+		want, err := c.Matches(m)
+		wantErr := err != nil
+
+		check := func(what string, got bool, err error) {
+			if got != want || (err != nil) != wantErr {
+				t.Fatalf("%s: got %v (%v), want %v (error %v)", what, got, err, want, wantErr)
+			}
+		}
+
+		// Maps are iterated in a different order each time.
+		for i := 0; i < 4; i++ {
+			got, err := c.Matches(m)
+			check("again", got, err)
+
+			y, err := decodeSelection([]byte(pat), everything)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := cfg.ParsePattern(y)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err = d.Matches(m)
+			check("reparsed", got, err)
+		}
+
+		if !wantErr {
+			got, err := MatchesJSON(c, []byte(msg))
+			check("MatchesJSON", got, err)
+
+			machine := NewMachine()
+			machine.Add("p", c)
+			ids := machine.Matches(m)
+			if got := len(ids) == 1; got != want {
+				t.Fatalf("Machine: got %v, want %v", ids, want)
+			}
+
+			// m has json.Numbers, like the bus's messages.
+			got, err = MatchesValue(c, m)
+			check("MatchesValue", got, err)
+
+			ids, err = machine.MatchesValue(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(ids) == 1; got != want {
+				t.Fatalf("Machine.MatchesValue: got %v, want %v", ids, want)
+			}
+		}
+
+		js, err := marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		y, err := decodeSelection(js, everything)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := cfg.ParsePattern(y)
+		if err != nil {
+			t.Fatalf("%s doesn't parse: %s", js, err)
+		}
+		got, err := d.Matches(m)
+		check("round trip "+string(js), got, err)
+	})
+}
@@ -0,0 +1,176 @@
+package pat
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Examples are events generated from a pattern.
+//
+// See Generate.
+type Examples struct {
+	// Matching has a small event that matches the pattern for each
+	// of the pattern's alternatives (due to "$or").
+	Matching []interface{} `json:"matching"`
+
+	// NearMisses are events that each fail one clause of the
+	// pattern but are otherwise like a Matching event.
+	NearMisses []NearMiss `json:"nearMisses"`
+}
+
+// NearMiss is an event that fails one clause of a pattern.
+type NearMiss struct {
+	// Path is the location (like "detail.price") of the clause
+	// that the event fails.
+	Path string `json:"path"`
+
+	// Clause is the pattern at that path.
+	Clause interface{} `json:"clause"`
+
+	// Event is the event, which doesn't match the pattern.
+	Event interface{} `json:"event"`
+}
+
+// Generate makes minimal events that match the given pattern and
+// near-miss events that fail each of its clauses.  Every event is
+// checked against the pattern.
+//
+// A Matching event only has the properties that the pattern
+// requires, and each value is the simplest that this function found
+// for the clause.  For each clause, a NearMiss event changes that
+// clause's value to one that fails the clause, preferring a similar
+// value (like a number just outside of a numeric range) to leaving
+// the property out.
+//
+// The pattern must be a Map.  Generate returns an error if it can't
+// find any matching event.
+func Generate(c Constraint) (*Examples, error) {
+	m, is := c.(Map)
+	if !is {
+		return nil, fmt.Errorf("can only generate events for a Map, not a %T", c)
+	}
+
+	var (
+		acc      = &Examples{}
+		seen     = make(map[string]bool)
+		seenMiss = make(map[string]bool)
+	)
+
+	for _, conj := range conjunctions(m) {
+		paths, groups := conj.groups()
+
+		// The first value (in the order of fieldSamples) that
+		// satisfies each group.
+		values := make([]interface{}, len(paths))
+		ok := true
+		for i, p := range paths {
+			ps := groups[pathKey(p)]
+			found := false
+			for _, v := range fieldSamples(ps, nil) {
+				if fieldMatchesAll(ps, v) {
+					values[i], found = v, true
+					break
+				}
+			}
+			if !found {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		build := func(i int, v interface{}) map[string]interface{} {
+			msg := make(map[string]interface{})
+			for j, p := range paths {
+				x := values[j]
+				if j == i {
+					x = v
+				}
+				place(msg, p, x)
+			}
+			return msg
+		}
+
+		msg := build(-1, nil)
+		if !matches(c, msg) {
+			continue
+		}
+		if js := JSON(msg); !seen[js] {
+			seen[js] = true
+			acc.Matching = append(acc.Matching, msg)
+		}
+
+		for i, p := range paths {
+			ps := groups[pathKey(p)]
+			for _, v := range nearSamples(ps) {
+				if fieldMatchesAll(ps, v) {
+					continue
+				}
+				miss := build(i, v)
+				if matches(c, miss) {
+					// Another alternative matches.
+					continue
+				}
+				path := ""
+				for _, k := range p {
+					path = pathField(path, k)
+				}
+				nm := NearMiss{
+					Path:   path,
+					Clause: oneOrAll(ps),
+					Event:  miss,
+				}
+				if js := JSON(nm); !seenMiss[js] {
+					seenMiss[js] = true
+					acc.NearMisses = append(acc.NearMisses, nm)
+				}
+				break
+			}
+		}
+	}
+
+	if len(acc.Matching) == 0 {
+		return nil, fmt.Errorf("can't generate an event that matches %s", JSON(c))
+	}
+
+	sort.Slice(acc.Matching, func(i, j int) bool {
+		return JSON(acc.Matching[i]) < JSON(acc.Matching[j])
+	})
+	sort.SliceStable(acc.NearMisses, func(i, j int) bool {
+		x, y := acc.NearMisses[i], acc.NearMisses[j]
+		if x.Path != y.Path {
+			return x.Path < y.Path
+		}
+		return JSON(x.Event) < JSON(y.Event)
+	})
+
+	return acc, nil
+}
+
+// oneOrAll returns the single pattern or all of the patterns.
+func oneOrAll(ps []interface{}) interface{} {
+	if len(ps) == 1 {
+		return ps[0]
+	}
+	return ps
+}
+
+// nearSamples is fieldSamples reordered for near misses: values
+// first, then Missing, and then arrays.
+func nearSamples(ps []interface{}) []interface{} {
+	var scalars, arrays []interface{}
+	for _, v := range fieldSamples(ps, nil) {
+		switch v.(type) {
+		case []interface{}:
+			arrays = append(arrays, v)
+		default:
+			if v != Missing {
+				scalars = append(scalars, v)
+			}
+		}
+	}
+	acc := append(scalars, Missing)
+	return append(acc, arrays...)
+}
@@ -0,0 +1,79 @@
+package pat
+
+import (
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	c, err := ParsePattern(P(`{"source":["shop"],"detail":{"price":[{"numeric":[">",10,"<=",20]}],"tag":[{"exists":false}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := Generate(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(x.Matching) != 1 {
+		t.Fatal(JSON(x))
+	}
+	if got, want := JSON(x.Matching[0]), `{"detail":{"price":11},"source":"shop"}`+"\n"; got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+	paths := make([]string, len(x.NearMisses))
+	for i, nm := range x.NearMisses {
+		paths[i] = nm.Path
+	}
+	if got, want := JSON(paths), `["detail.price","detail.tag","source"]`+"\n"; got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+	if got, want := JSON(x.NearMisses[0].Event), `{"detail":{"price":10},"source":"shop"}`+"\n"; got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+}
+
+func TestGenerateOr(t *testing.T) {
+	c, err := ParsePattern(P(`{"$or":[{"a":["x"]},{"b":[{"prefix":"y"}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := Generate(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(x.Matching) != 2 || len(x.NearMisses) != 2 {
+		t.Fatal(JSON(x))
+	}
+}
+
+// TestGenerateCases checks the generated events for the patterns in
+// tests.json.
+func TestGenerateCases(t *testing.T) {
+	for _, tc := range readCases(t) {
+		if tc.Error {
+			continue
+		}
+		c, err := tc.cfg().ParsePattern(tc.Pat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, is := c.(Map); !is {
+			continue
+		}
+		t.Run(JSON(tc.Pat), func(t *testing.T) {
+			x, err := Generate(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, msg := range x.Matching {
+				if ok, err := c.Matches(msg); !ok || err != nil {
+					t.Fatalf("%s: %v %v", JSON(msg), ok, err)
+				}
+			}
+			for _, nm := range x.NearMisses {
+				if ok, err := c.Matches(nm.Event); ok || err != nil {
+					t.Fatalf("%s: %v %v", JSON(nm), ok, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package pat
+
+import (
+	"sort"
+	"sync"
+)
+
+// intervals indexes the Numeric clauses at a node so that the clauses
+// whose intervals might contain a number can be found without
+// looking at the others.
+//
+// The index is a centered interval tree, which is rebuilt lazily
+// after the set of intervals changes.
+type intervals struct {
+	items []interval
+
+	sync.Mutex
+	tree  *itree
+	dirty bool
+}
+
+type interval struct {
+	c *Numeric
+
+	// lower and upper are the bounds, which are nil when
+	// unbounded.
+	lower, upper *number
+
+	clause *clause
+}
+
+// newInterval makes an interval for the given Numeric if its bounds
+// are numbers.
+func newInterval(n *Numeric, c *clause) (interval, bool) {
+	i := interval{
+		c:      n,
+		clause: c,
+	}
+	if n.Lower != nil {
+		x, ok := toNumber(n.Lower.Value)
+		if !ok {
+			return i, false
+		}
+		i.lower = &x
+	}
+	if n.Upper != nil {
+		x, ok := toNumber(n.Upper.Value)
+		if !ok {
+			return i, false
+		}
+		i.upper = &x
+	}
+	return i, i.lower != nil || i.upper != nil
+}
+
+func (is *intervals) add(i interval) {
+	is.items = append(is.items, i)
+	is.dirty = true
+}
+
+func (is *intervals) remove(n *Numeric, c *clause) {
+	acc := is.items[:0]
+	for _, i := range is.items {
+		if i.c != n || i.clause != c {
+			acc = append(acc, i)
+		}
+	}
+	is.items = acc
+	is.dirty = true
+}
+
+func (is *intervals) len() int {
+	return len(is.items)
+}
+
+// stab calls the given function with each clause whose interval
+// contains the given value.
+//
+// Callers hold a read lock on the Machine, so the items don't change,
+// but more than one caller might want to rebuild the tree.
+func (is *intervals) stab(v interface{}, f func(*clause)) {
+	x, ok := toNumber(v)
+	if !ok {
+		return
+	}
+	is.Lock()
+	if is.dirty {
+		items := make([]interval, len(is.items))
+		copy(items, is.items)
+		is.tree = buildTree(items)
+		is.dirty = false
+	}
+	t := is.tree
+	is.Unlock()
+
+	t.stab(x, func(i interval) {
+		if ok, err := i.c.Matches(v); ok && err == nil {
+			f(i.clause)
+		}
+	})
+}
+
+// itree is a node in a centered interval tree.  The intervals at a
+// node all contain (or touch) the center, and the intervals entirely
+// below or above the center are in the left or right subtree.
+type itree struct {
+	center number
+
+	// byLower is sorted by lower bound (ascending), and byUpper
+	// is sorted by upper bound (descending).
+	byLower, byUpper []interval
+
+	left, right *itree
+}
+
+func buildTree(items []interval) *itree {
+	if len(items) == 0 {
+		return nil
+	}
+
+	var ends []number
+	for _, i := range items {
+		if i.lower != nil {
+			ends = append(ends, *i.lower)
+		}
+		if i.upper != nil {
+			ends = append(ends, *i.upper)
+		}
+	}
+	sort.Slice(ends, func(i, j int) bool {
+		return ends[i].cmp(ends[j]) < 0
+	})
+
+	t := &itree{
+		center: ends[len(ends)/2],
+	}
+	var left, right []interval
+	for _, i := range items {
+		switch {
+		case i.upper != nil && i.upper.cmp(t.center) < 0:
+			left = append(left, i)
+		case i.lower != nil && 0 < i.lower.cmp(t.center):
+			right = append(right, i)
+		default:
+			t.byLower = append(t.byLower, i)
+		}
+	}
+	t.byUpper = make([]interval, len(t.byLower))
+	copy(t.byUpper, t.byLower)
+	sort.Slice(t.byLower, func(i, j int) bool {
+		return lowerLess(t.byLower[i], t.byLower[j])
+	})
+	sort.Slice(t.byUpper, func(i, j int) bool {
+		return upperLess(t.byUpper[j], t.byUpper[i])
+	})
+	t.left = buildTree(left)
+	t.right = buildTree(right)
+	return t
+}
+
+// lowerLess orders intervals by lower bound, where no bound is the
+// least.
+func lowerLess(i, j interval) bool {
+	switch {
+	case j.lower == nil:
+		return false
+	case i.lower == nil:
+		return true
+	}
+	return i.lower.cmp(*j.lower) < 0
+}
+
+// upperLess orders intervals by upper bound, where no bound is the
+// greatest.
+func upperLess(i, j interval) bool {
+	switch {
+	case i.upper == nil:
+		return false
+	case j.upper == nil:
+		return true
+	}
+	return i.upper.cmp(*j.upper) < 0
+}
+
+// stab calls the given function with the intervals that might
+// contain the given number.  Since the bounds might be exclusive, the
+// function has to check.
+func (t *itree) stab(x number, f func(interval)) {
+	for t != nil {
+		switch d := x.cmp(t.center); {
+		case d < 0:
+			for _, i := range t.byLower {
+				if i.lower != nil && 0 < i.lower.cmp(x) {
+					break
+				}
+				f(i)
+			}
+			t = t.left
+		case 0 < d:
+			for _, i := range t.byUpper {
+				if i.upper != nil && i.upper.cmp(x) < 0 {
+					break
+				}
+				f(i)
+			}
+			t = t.right
+		default:
+			for _, i := range t.byLower {
+				f(i)
+			}
+			return
+		}
+	}
+}
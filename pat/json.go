@@ -0,0 +1,161 @@
+package pat
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// The MarshalJSON methods below render Constraints back into the
+// pattern syntax that ParsePattern accepts.  The output is canonical:
+// object keys are sorted, arrays that are sets (Constraints, Or, and
+// anything-but values) are sorted and deduplicated, and numeric
+// ranges are normalized.  Therefore equivalent patterns (in those
+// respects) serialize identically.
+
+// marshal is json.Marshal without the HTML escaping.
+func marshal(x interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	e := json.NewEncoder(buf)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(x); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// canonical returns the given value with a number replaced by its
+// canonical form (see number.text), so that equal numbers serialize
+// identically.
+func canonical(x interface{}) interface{} {
+	if n, ok := toNumber(x); ok {
+		return json.Number(n.text())
+	}
+	return x
+}
+
+// marshalSet renders the given values as a sorted JSON array without
+// duplicates.  Equal numbers are duplicates.
+func marshalSet(xs []interface{}) ([]byte, error) {
+	ss := make([]string, 0, len(xs))
+	have := make(map[string]bool, len(xs))
+	for _, x := range xs {
+		js, err := marshal(canonical(x))
+		if err != nil {
+			return nil, err
+		}
+		s := string(js)
+		if have[s] {
+			continue
+		}
+		have[s] = true
+		ss = append(ss, s)
+	}
+	sort.Strings(ss)
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte('[')
+	for i, s := range ss {
+		if 0 < i {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(s)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+func (c Map) MarshalJSON() ([]byte, error) {
+	return marshal(map[string]interface{}(c))
+}
+
+func (cs Constraints) MarshalJSON() ([]byte, error) {
+	xs := make([]interface{}, len(cs))
+	for i, c := range cs {
+		xs[i] = c
+	}
+	return marshalSet(xs)
+}
+
+func (c Or) MarshalJSON() ([]byte, error) {
+	xs := make([]interface{}, len(c))
+	for i, d := range c {
+		xs[i] = d
+	}
+	return marshalSet(xs)
+}
+
+func (c *Literal) MarshalJSON() ([]byte, error) {
+	return marshal(canonical(c.Value))
+}
+
+// op renders an operator (like "prefix") with its argument.
+func op(name string, x interface{}) ([]byte, error) {
+	return marshal(map[string]interface{}{
+		name: x,
+	})
+}
+
+func (c *AnythingBut) MarshalJSON() ([]byte, error) {
+	if c.Constraint != nil {
+		return op("anything-but", c.Constraint)
+	}
+	js, err := marshalSet(c.Value)
+	if err != nil {
+		return nil, err
+	}
+	return op("anything-but", json.RawMessage(js))
+}
+
+func (c *Prefix) MarshalJSON() ([]byte, error) {
+	return op("prefix", c.Value)
+}
+
+func (c *Suffix) MarshalJSON() ([]byte, error) {
+	return op("suffix", c.Value)
+}
+
+func (c *EqualsIgnoreCase) MarshalJSON() ([]byte, error) {
+	return op("equals-ignore-case", c.Value)
+}
+
+func (c *Wildcard) MarshalJSON() ([]byte, error) {
+	return op("wildcard", c.Value)
+}
+
+func (c *CIDR) MarshalJSON() ([]byte, error) {
+	return op("cidr", c.Value)
+}
+
+func (c *Exists) MarshalJSON() ([]byte, error) {
+	return op("exists", c.Value)
+}
+
+func (c *Numeric) MarshalJSON() ([]byte, error) {
+	return op("numeric", c.relations())
+}
+
+// relations renders the interval as the argument of "numeric".
+func (c *Numeric) relations() []interface{} {
+	if v, ok := c.Point(); ok {
+		return []interface{}{"=", canonical(v)}
+	}
+	xs := make([]interface{}, 0, 4)
+	for _, p := range []*NumericPredicate{c.Lower, c.Upper} {
+		if p != nil {
+			xs = append(xs, p.Relation, canonical(p.Value))
+		}
+	}
+	return xs
+}
+
+func (c *Element) MarshalJSON() ([]byte, error) {
+	return op("element", map[string]interface{}{
+		"index":   c.Index,
+		"pattern": c.Pattern,
+	})
+}
+
+func (c *Length) MarshalJSON() ([]byte, error) {
+	return op("length", c.Numeric.relations())
+}
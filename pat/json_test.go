@@ -0,0 +1,76 @@
+package pat
+
+import (
+	"testing"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	try := func(in, want string) {
+		t.Run(in, func(t *testing.T) {
+			c, err := ParsePattern(P(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			js, err := marshal(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(js); got != want {
+				t.Fatalf("want %s, got %s", want, got)
+			}
+		})
+	}
+
+	try(`{"b":["y","x","y"],"a":[2,1]}`, `{"a":[1,2],"b":["x","y"]}`)
+	try(`{"a":[3,3.0,3e0]}`, `{"a":[3]}`)
+	try(`{"a":[{"anything-but":[0.5,5e-1,1e21,1e+21]}]}`, `{"a":[{"anything-but":[0.5,1e+21]}]}`)
+	try(`{"a":[{"numeric":[">",1.0,"<=",2e1]}]}`, `{"a":[{"numeric":[">",1,"<=",20]}]}`)
+	try(`{"a":[{"numeric":["<",10,">=",0]}]}`, `{"a":[{"numeric":[">=",0,"<",10]}]}`)
+	try(`{"a":[{"numeric":["<=",10,">=",10]}]}`, `{"a":[{"numeric":["=",10]}]}`)
+	try(`{"a":[{"anything-but":["b","a","b"]}]}`, `{"a":[{"anything-but":["a","b"]}]}`)
+	try(`{"a":[{"anything-but":{"suffix":".tmp"}}]}`, `{"a":[{"anything-but":{"suffix":".tmp"}}]}`)
+	try(`{"a":[{"anything-but":{"prefix":"test-"}}]}`, `{"a":[{"anything-but":{"prefix":"test-"}}]}`)
+	try(`{"a":[{"anything-but":"x"}]}`, `{"a":[{"anything-but":["x"]}]}`)
+	try(`{"a":[{"prefix":"p"},{"suffix":"s"},{"exists":true}]}`, `{"a":[{"exists":true},{"prefix":"p"},{"suffix":"s"}]}`)
+	try(`{"a":[{"wildcard":"a\\*b*"},{"cidr":"10.0.0.0/8"},{"equals-ignore-case":"X"}]}`,
+		`{"a":[{"cidr":"10.0.0.0/8"},{"equals-ignore-case":"X"},{"wildcard":"a\\*b*"}]}`)
+	try(`{"$or":[{"b":[1]},{"a":[1]},{"b":[1]}]}`, `{"$or":[{"a":[1]},{"b":[1]}]}`)
+	try(`{"a":"x"}`, `{"a":"x"}`)
+	try(`"x"`, `"x"`)
+}
+
+// TestRoundTrip checks that serializing and then reparsing the
+// patterns in tests.json gives the same serialization and the same
+// match results.
+func TestRoundTrip(t *testing.T) {
+	for _, tc := range readCases(t) {
+		if tc.Error {
+			continue
+		}
+		t.Run(JSON(tc), func(t *testing.T) {
+			c, err := tc.cfg().ParsePattern(tc.Pat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			js := JSON(c)
+			x, err := decodeSelection([]byte(js), everything)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := tc.cfg().ParsePattern(x)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again := JSON(d); again != js {
+				t.Fatalf("%s != %s", again, js)
+			}
+			got, err := d.Matches(tc.Msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.Matches {
+				t.Fatal(got)
+			}
+		})
+	}
+}
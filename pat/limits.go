@@ -0,0 +1,121 @@
+package pat
+
+import (
+	"fmt"
+)
+
+// Default limits for DefaultCfg.  These limits are generous for
+// hand-written patterns but keep a pattern from an untrusted source
+// from being pathological.
+const (
+	DefaultMaxDepth        = 16
+	DefaultMaxFields       = 256
+	DefaultMaxArrayLength  = 256
+	DefaultMaxStringLength = 1024
+	DefaultMaxLeaves       = 1024
+)
+
+// LimitError reports that a pattern exceeded one of the limits in a
+// Cfg.
+type LimitError struct {
+	// Limit is the name of the Cfg field (like "MaxDepth").
+	Limit string
+
+	// Max is the value of that limit.
+	Max int
+
+	// Got is the offending value, which might only be the count at
+	// the point where the limit was first exceeded.
+	Got int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("pattern exceeds %s (%d > %d)", e.Limit, e.Got, e.Max)
+}
+
+// limiter tracks the totals for the limits that are over the whole
+// pattern.
+type limiter struct {
+	cfg    *Cfg
+	fields int
+	leaves int
+}
+
+// checkLimits checks the given (not yet parsed) pattern against the
+// Cfg's limits.  An error is a *ParseError that wraps a *LimitError.
+func (cfg *Cfg) checkLimits(x interface{}) error {
+	l := &limiter{
+		cfg: cfg,
+	}
+	return l.check(x, "", 1)
+}
+
+func exceeds(max, got int) bool {
+	return 0 < max && max < got
+}
+
+// exceeded makes the error for exceeding the named limit.
+func exceeded(path string, x interface{}, limit string, max, got int) error {
+	return &ParseError{
+		Path:  path,
+		Value: x,
+		Code:  CodeLimit,
+		Err: &LimitError{
+			Limit: limit,
+			Max:   max,
+			Got:   got,
+		},
+	}
+}
+
+func (l *limiter) check(x interface{}, path string, depth int) error {
+	cfg := l.cfg
+	switch vv := x.(type) {
+	case map[string]interface{}:
+		if exceeds(cfg.MaxDepth, depth) {
+			return exceeded(path, x, "MaxDepth", cfg.MaxDepth, depth)
+		}
+		l.fields += len(vv)
+		if exceeds(cfg.MaxFields, l.fields) {
+			return exceeded(path, x, "MaxFields", cfg.MaxFields, l.fields)
+		}
+		for _, k := range sortedKeys(vv) {
+			v := vv[k]
+			if exceeds(cfg.MaxStringLength, len(k)) {
+				return exceeded(path, k, "MaxStringLength", cfg.MaxStringLength, len(k))
+			}
+			if err := l.check(v, pathField(path, k), depth+1); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if exceeds(cfg.MaxDepth, depth) {
+			return exceeded(path, x, "MaxDepth", cfg.MaxDepth, depth)
+		}
+		if exceeds(cfg.MaxArrayLength, len(vv)) {
+			return exceeded(path, x, "MaxArrayLength", cfg.MaxArrayLength, len(vv))
+		}
+		for i, v := range vv {
+			if err := l.check(v, pathIndex(path, i), depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		l.leaves++
+		if exceeds(cfg.MaxLeaves, l.leaves) {
+			return exceeded(path, x, "MaxLeaves", cfg.MaxLeaves, l.leaves)
+		}
+		if s, is := x.(string); is && exceeds(cfg.MaxStringLength, len(s)) {
+			return exceeded(path, x, "MaxStringLength", cfg.MaxStringLength, len(s))
+		}
+		if err := checkExponent(x); err != nil {
+			return &ParseError{
+				Path:  path,
+				Value: x,
+				Code:  CodeValue,
+				Err:   err,
+			}
+		}
+	}
+	return nil
+}
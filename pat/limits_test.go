@@ -0,0 +1,55 @@
+package pat
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	cfg := &Cfg{
+		MaxDepth:        4,
+		MaxFields:       4,
+		MaxArrayLength:  3,
+		MaxStringLength: 8,
+		MaxLeaves:       5,
+	}
+
+	try := func(js string, limit string) {
+		t.Run(js, func(t *testing.T) {
+			_, err := cfg.ParsePattern(P(js))
+			if limit == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var le *LimitError
+			if !errors.As(err, &le) {
+				t.Fatalf("want a LimitError, got %v", err)
+			}
+			if le.Limit != limit {
+				t.Fatalf("want %s, got %s", limit, le.Limit)
+			}
+			if _, err := (&Cfg{}).ParsePattern(P(js)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	try(`{"a":{"b":[{"prefix":"x"}]}}`, "")
+	try(`{"a":{"b":{"c":{"d":["x"]}}}}`, "MaxDepth")
+	try(`{"a":["x"],"b":["x"],"c":["x"],"d":["x"],"e":["x"]}`, "MaxFields")
+	try(`{"a":["w","x","y","z"]}`, "MaxArrayLength")
+	try(`{"a":["abcdefghi"]}`, "MaxStringLength")
+	try(`{"abcdefghi":["x"]}`, "MaxStringLength")
+	try(`{"a":["w","x","y"],"b":["x","y","z"]}`, "MaxLeaves")
+
+	// A pathologically deep pattern.
+	js := strings.Repeat(`{"a":`, 1000) + `["x"]` + strings.Repeat(`}`, 1000)
+	try(js, "MaxDepth")
+
+	if _, err := ParsePattern(P(js)); err == nil {
+		t.Fatal("expected an error from DefaultCfg")
+	}
+}
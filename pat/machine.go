@@ -0,0 +1,613 @@
+package pat
+
+import (
+	"sort"
+	"sync"
+)
+
+// Machine is a compiled set of named patterns that can be matched
+// against a message in one pass.
+//
+// A pattern that's a Map is decomposed into clauses: one per leaf
+// path.  Those clauses are indexed by path, and clauses consisting
+// only of literals, prefixes, numeric ranges, and "exists": true are
+// further indexed by value: literals in a hash table, prefixes by
+// each prefix of a string in the message, and numeric ranges in an
+// interval tree.  Matching a message then walks the message (but only
+// the parts that some pattern mentions) and counts satisfied clauses
+// per pattern.  When a pattern has any indexed clauses, its other
+// clauses are only evaluated if all of those indexed clauses are
+// satisfied.  (See AWS's Ruler for the real thing.)
+//
+// So the cost grows with the size of the message (and the number of
+// matches) rather than with the number of patterns, except for
+// patterns that have no indexed clauses at all (like a pattern with
+// only "suffix" or "anything-but" clauses).  Those clauses are
+// evaluated whenever their paths are present in a message, and
+// patterns that match a message without any of their paths are
+// considered for every message.  See BenchmarkMachineIndexes.
+//
+// Patterns that aren't Maps are just evaluated directly.
+//
+// A Machine is safe for concurrent use.
+type Machine struct {
+	sync.RWMutex
+
+	root *node
+
+	// entries maps pattern ids to their compiled representations
+	// (one per combination).
+	entries map[string][]*entry
+
+	// defaults are the unanchored entries that match a message
+	// that has none of their paths.
+	defaults map[*entry]bool
+
+	// others are the patterns that aren't indexed.
+	others map[string]*entry
+
+	// otherPaths is the selection of the paths that the others
+	// mention.
+	otherPaths *paths
+}
+
+// NewMachine makes an empty Machine.
+func NewMachine() *Machine {
+	return &Machine{
+		root:     newNode(),
+		entries:  make(map[string][]*entry),
+		defaults: make(map[*entry]bool),
+		others:   make(map[string]*entry),
+
+		otherPaths: newPaths(),
+	}
+}
+
+type entry struct {
+	id      string
+	c       Constraint
+	clauses []*clause
+
+	// indexed is the number of clauses that are indexed by
+	// value.  When that number isn't zero, the entry is
+	// "anchored", and its other clauses (evals) are only
+	// evaluated after its indexed clauses are satisfied.
+	indexed int
+	evals   []*clause
+}
+
+type clause struct {
+	entry *entry
+	node  *node
+
+	// pat is the pattern at the clause's path.
+	pat interface{}
+
+	// indexed reports whether the clause is in its node's
+	// indexes.
+	indexed bool
+
+	// absent reports whether the clause is satisfied when its
+	// path is missing.
+	absent bool
+}
+
+type node struct {
+	children map[string]*node
+
+	// values indexes clauses by the literal values that satisfy
+	// them.
+	values map[interface{}][]*clause
+
+	// prefixes indexes clauses by the prefixes that satisfy
+	// them.
+	prefixes map[string][]*clause
+
+	// prefixLen is the length of the longest key in prefixes.
+	prefixLen int
+
+	// numerics indexes clauses by the numeric ranges that satisfy
+	// them.
+	numerics *intervals
+
+	// exists are the clauses that are satisfied by any value with
+	// a leaf.
+	exists []*clause
+
+	// evals are the clauses of unanchored entries that need to
+	// be evaluated.
+	evals []*clause
+
+	// clauses is the number of clauses at this node.
+	clauses int
+}
+
+// whole reports whether any clauses need this node's value, which
+// makes a node a selection (see MatchesJSON).
+func (n *node) whole() bool {
+	return 0 < n.clauses
+}
+
+func (n *node) sub(k string) selection {
+	if child, have := n.children[k]; have {
+		return child
+	}
+	return nil
+}
+
+func newNode() *node {
+	return &node{
+		children: make(map[string]*node),
+		values:   make(map[interface{}][]*clause),
+		prefixes: make(map[string][]*clause),
+		numerics: &intervals{},
+	}
+}
+
+// indexKey returns the key for the given literal value in a node's
+// values index.  Numbers are keyed so that equal numbers of
+// different types share a key.
+func indexKey(x interface{}) (interface{}, bool) {
+	switch vv := x.(type) {
+	case string, bool:
+		return vv, true
+	case nil:
+		return nullKey{}, true
+	}
+	if n, ok := toNumber(x); ok {
+		return n.key(), true
+	}
+	return nil, false
+}
+
+// nullKey is the index key for null.
+type nullKey struct{}
+
+// indexable reports whether the given pattern is Constraints that
+// can all be indexed.
+func indexable(x interface{}) bool {
+	cs, is := x.(Constraints)
+	if !is || len(cs) == 0 {
+		return false
+	}
+	for _, c := range cs {
+		switch vv := c.(type) {
+		case *Literal:
+			if _, ok := indexKey(vv.Value); !ok {
+				return false
+			}
+		case *Prefix:
+		case *Numeric:
+			if _, ok := newInterval(vv, nil); !ok {
+				return false
+			}
+		case *Exists:
+			if !vv.Value {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// index adds the given indexable clause to its node's indexes.
+func (n *node) index(c *clause) {
+	for _, x := range c.pat.(Constraints) {
+		switch vv := x.(type) {
+		case *Literal:
+			k, _ := indexKey(vv.Value)
+			n.values[k] = append(n.values[k], c)
+		case *Prefix:
+			n.prefixes[vv.Value] = append(n.prefixes[vv.Value], c)
+			if n.prefixLen < len(vv.Value) {
+				n.prefixLen = len(vv.Value)
+			}
+		case *Numeric:
+			i, _ := newInterval(vv, c)
+			n.numerics.add(i)
+		case *Exists:
+			n.exists = append(n.exists, c)
+		}
+	}
+}
+
+// unindex removes the given indexed clause from its node's indexes.
+func (n *node) unindex(c *clause) {
+	for _, x := range c.pat.(Constraints) {
+		switch vv := x.(type) {
+		case *Literal:
+			k, _ := indexKey(vv.Value)
+			if cs := without(n.values[k], c); 0 < len(cs) {
+				n.values[k] = cs
+			} else {
+				delete(n.values, k)
+			}
+		case *Prefix:
+			if cs := without(n.prefixes[vv.Value], c); 0 < len(cs) {
+				n.prefixes[vv.Value] = cs
+			} else {
+				delete(n.prefixes, vv.Value)
+				if len(vv.Value) == n.prefixLen {
+					n.prefixLen = 0
+					for p := range n.prefixes {
+						if n.prefixLen < len(p) {
+							n.prefixLen = len(p)
+						}
+					}
+				}
+			}
+		case *Numeric:
+			n.numerics.remove(vv, c)
+		case *Exists:
+			n.exists = without(n.exists, c)
+		}
+	}
+}
+
+// Add compiles the given pattern and adds it with the given id.  Any
+// existing pattern with that id is replaced.
+//
+// A Map with "$or"s is expanded into its combinations (see
+// Combinations), each of which is indexed separately.
+func (m *Machine) Add(id string, c Constraint) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.remove(id)
+
+	var alts [][]leaf
+	if p, is := c.(Map); is && 0 < len(p) {
+		alts = alternatives(p, nil)
+	}
+
+	if !compilable(alts) {
+		e := &entry{
+			id: id,
+			c:  c,
+		}
+		m.entries[id] = []*entry{e}
+		m.others[id] = e
+		m.otherPaths.add(c)
+		return
+	}
+
+	es := make([]*entry, len(alts))
+	for i, ls := range alts {
+		es[i] = m.compile(id, c, ls)
+	}
+	m.entries[id] = es
+}
+
+// compilable reports whether every alternative has at least one leaf
+// and no leaf is at the root.
+func compilable(alts [][]leaf) bool {
+	if len(alts) == 0 {
+		return false
+	}
+	for _, ls := range alts {
+		if len(ls) == 0 {
+			return false
+		}
+		for _, l := range ls {
+			if len(l.path) == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (m *Machine) compile(id string, p Constraint, ls []leaf) *entry {
+	e := &entry{
+		id: id,
+		c:  p,
+	}
+
+	for _, l := range ls {
+		n := m.root
+		for _, k := range l.path {
+			child, have := n.children[k]
+			if !have {
+				child = newNode()
+				n.children[k] = child
+			}
+			n = child
+		}
+		c := &clause{
+			entry: e,
+			node:  n,
+			pat:   l.pat,
+		}
+		e.clauses = append(e.clauses, c)
+		n.clauses++
+		if indexable(l.pat) {
+			c.indexed = true
+			e.indexed++
+			n.index(c)
+		} else {
+			e.evals = append(e.evals, c)
+		}
+	}
+
+	if 0 < e.indexed {
+		return e
+	}
+
+	dflt := true
+	for _, c := range e.evals {
+		c.node.evals = append(c.node.evals, c)
+		if ok, err := Matches(c.pat, Missing); ok && err == nil {
+			c.absent = true
+		} else {
+			dflt = false
+		}
+	}
+	if dflt {
+		m.defaults[e] = true
+	}
+
+	return e
+}
+
+// Remove removes the pattern with the given id (if any).
+func (m *Machine) Remove(id string) {
+	m.Lock()
+	m.remove(id)
+	m.Unlock()
+}
+
+func without(cs []*clause, c *clause) []*clause {
+	acc := cs[:0]
+	for _, x := range cs {
+		if x != c {
+			acc = append(acc, x)
+		}
+	}
+	return acc
+}
+
+func (m *Machine) remove(id string) {
+	es, have := m.entries[id]
+	if !have {
+		return
+	}
+	delete(m.entries, id)
+	if _, have := m.others[id]; have {
+		delete(m.others, id)
+		m.otherPaths = newPaths()
+		for _, e := range m.others {
+			m.otherPaths.add(e.c)
+		}
+	}
+	for _, e := range es {
+		delete(m.defaults, e)
+		for _, c := range e.clauses {
+			n := c.node
+			n.clauses--
+			switch {
+			case c.indexed:
+				n.unindex(c)
+			case e.indexed == 0:
+				n.evals = without(n.evals, c)
+			}
+		}
+	}
+	// Empty nodes are left in place.  They are harmless.
+}
+
+// Len returns the number of patterns in the Machine.
+func (m *Machine) Len() int {
+	m.RLock()
+	defer m.RUnlock()
+	return len(m.entries)
+}
+
+type state struct {
+	// values are the message's values at the visited nodes.
+	values    map[*node]interface{}
+	satisfied map[*clause]bool
+}
+
+func (m *Machine) walk(n *node, x interface{}, s *state) {
+	msg, is := x.(map[string]interface{})
+	if !is {
+		return
+	}
+	for k, v := range msg {
+		child, have := n.children[k]
+		if !have {
+			continue
+		}
+		s.values[child] = v
+		if xs, is := v.([]interface{}); is {
+			for _, x := range xs {
+				s.lookup(child, x)
+			}
+		} else {
+			s.lookup(child, v)
+		}
+		if 0 < len(child.exists) && hasLeaf(v) {
+			for _, c := range child.exists {
+				s.satisfied[c] = true
+			}
+		}
+		for _, c := range child.evals {
+			if s.satisfied[c] {
+				continue
+			}
+			if ok, err := Matches(c.pat, v); ok && err == nil {
+				s.satisfied[c] = true
+			}
+		}
+		if 0 < len(child.children) {
+			m.walk(child, v, s)
+		}
+	}
+}
+
+// eval evaluates the given anchored entry's other clauses.
+func (s *state) eval(e *entry) bool {
+	for _, c := range e.evals {
+		v, have := s.values[c.node]
+		if !have {
+			v = Missing
+		}
+		if ok, err := Matches(c.pat, v); !ok || err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// unanchored reports whether all of the given unanchored entry's
+// clauses are satisfied: each clause at a path in the message was
+// satisfied by the value there, and each other clause is satisfied
+// by a missing value.
+func (s *state) unanchored(e *entry) bool {
+	for _, c := range e.clauses {
+		if _, have := s.values[c.node]; have {
+			if !s.satisfied[c] {
+				return false
+			}
+		} else if !c.absent {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup marks the indexed clauses that the given value (which isn't
+// an array) satisfies.
+func (s *state) lookup(n *node, x interface{}) {
+	if k, ok := indexKey(x); ok {
+		for _, c := range n.values[k] {
+			s.satisfied[c] = true
+		}
+	}
+	if str, is := x.(string); is && 0 < len(n.prefixes) {
+		// Only the string's prefixes up to the longest indexed
+		// prefix can be keys, so a long string costs no more
+		// than a short one.
+		max := len(str)
+		if n.prefixLen < max {
+			max = n.prefixLen
+		}
+		for i := 0; i <= max; i++ {
+			for _, c := range n.prefixes[str[:i]] {
+				s.satisfied[c] = true
+			}
+		}
+	}
+	if 0 < n.numerics.len() {
+		n.numerics.stab(x, func(c *clause) {
+			s.satisfied[c] = true
+		})
+	}
+}
+
+// selection returns the selection of the parts of a message that
+// some pattern mentions.  The caller must hold a lock.
+func (m *Machine) selection() selection {
+	if len(m.others) == 0 {
+		return m.root
+	}
+	return union{m.root, m.otherPaths}
+}
+
+// union is a selection of what either of its selections selects.
+type union struct {
+	a, b selection
+}
+
+func (u union) whole() bool {
+	return u.a.whole() || u.b.whole()
+}
+
+func (u union) sub(k string) selection {
+	a, b := u.a.sub(k), u.b.sub(k)
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	return union{a, b}
+}
+
+// MatchesJSON is Matches for a JSON document.  Only the parts of the
+// document that some pattern mentions are decoded.
+func (m *Machine) MatchesJSON(js []byte) ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	x, err := decodeSelection(js, m.selection())
+	if err != nil {
+		return nil, err
+	}
+	return m.matches(x), nil
+}
+
+// Matches returns the sorted ids of the patterns that match the
+// given message.
+//
+// As with Constraints, a pattern that returns an error is considered
+// to not match.
+func (m *Machine) Matches(msg interface{}) []string {
+	m.RLock()
+	defer m.RUnlock()
+	return m.matches(msg)
+}
+
+func (m *Machine) matches(msg interface{}) []string {
+	s := &state{
+		values:    make(map[*node]interface{}),
+		satisfied: make(map[*clause]bool),
+	}
+
+	ids := make(map[string]bool)
+
+	if _, is := msg.(map[string]interface{}); is {
+		m.walk(m.root, msg, s)
+
+		counts := make(map[*entry]int)
+		for c := range s.satisfied {
+			counts[c.entry]++
+		}
+		for e := range m.defaults {
+			if _, have := counts[e]; !have {
+				counts[e] = 0
+			}
+		}
+
+		for e, n := range counts {
+			if ids[e.id] {
+				continue
+			}
+			if 0 < e.indexed {
+				if n != e.indexed || !s.eval(e) {
+					continue
+				}
+			} else if !s.unanchored(e) {
+				continue
+			}
+			ids[e.id] = true
+		}
+	}
+
+	for id, e := range m.others {
+		if ok, err := e.c.Matches(msg); ok && err == nil {
+			ids[id] = true
+		}
+	}
+
+	acc := make([]string, 0, len(ids))
+	for id := range ids {
+		acc = append(acc, id)
+	}
+
+	sort.Strings(acc)
+
+	return acc
+}
@@ -0,0 +1,298 @@
+package pat
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestMachineAgrees checks that a Machine holding all of the patterns
+// in tests.json agrees with each pattern's Matches method for every
+// message in tests.json.
+func TestMachineAgrees(t *testing.T) {
+
+	var (
+		cases = readCases(t)
+		m     = NewMachine()
+		pats  = make(map[string]Constraint)
+	)
+
+	for i, tc := range cases {
+		if tc.Error {
+			continue
+		}
+		c, err := tc.cfg().ParsePattern(tc.Pat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := strconv.Itoa(i)
+		pats[id] = c
+		m.Add(id, c)
+	}
+
+	for _, tc := range cases {
+		t.Run(JSON(tc.Msg), func(t *testing.T) {
+			want := make(map[string]bool)
+			for id, c := range pats {
+				if ok, _ := c.Matches(tc.Msg); ok {
+					want[id] = true
+				}
+			}
+			got := make(map[string]bool)
+			for _, id := range m.Matches(tc.Msg) {
+				got[id] = true
+			}
+			if !reflect.DeepEqual(want, got) {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			ids, err := m.MatchesJSON([]byte(JSON(tc.Msg)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ids, m.Matches(tc.Msg)) {
+				t.Fatalf("MatchesJSON %v", ids)
+			}
+		})
+	}
+}
+
+func TestMachine(t *testing.T) {
+	m := NewMachine()
+
+	add := func(id, js string) {
+		c, err := ParsePattern(P(js))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Add(id, c)
+	}
+
+	add("tacos", `{"want":["tacos","queso"]}`)
+	add("cheap", `{"want":["tacos"],"price":[{"numeric":["<",5]}]}`)
+	add("nested", `{"order":{"size":["large"]}}`)
+	add("absent", `{"want":[{"exists":false}]}`)
+	add("prefix", `{"want":[{"prefix":"ta"}]}`)
+
+	try := func(js string, want ...string) {
+		t.Run(js, func(t *testing.T) {
+			got := m.Matches(P(js))
+			if want == nil {
+				want = []string{}
+			}
+			if !reflect.DeepEqual(want, got) {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			if got, err := m.MatchesJSON([]byte(js)); err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(want, got) {
+				t.Fatalf("MatchesJSON want %v, got %v", want, got)
+			}
+		})
+	}
+
+	try(`{"want":"tacos","price":3}`, "cheap", "prefix", "tacos")
+	try(`{"want":"tacos","price":7}`, "prefix", "tacos")
+	try(`{"want":["chips","queso"]}`, "tacos")
+	try(`{"order":{"size":"large"}}`, "absent", "nested")
+	try(`{"order":"large"}`, "absent")
+	try(`"tacos"`)
+
+	// Removing the longest prefix still leaves the shorter one.
+	add("longer", `{"want":[{"prefix":"tacos-"}]}`)
+	try(`{"want":"tacos-al-pastor"}`, "longer", "prefix")
+	m.Remove("longer")
+	try(`{"want":"tacos-al-pastor"}`, "prefix")
+
+	m.Remove("tacos")
+	m.Add("absent", Pass)
+
+	try(`{"want":["chips","queso"]}`, "absent")
+	try(`"tacos"`, "absent")
+
+	if n := m.Len(); n != 4 {
+		t.Fatal(n)
+	}
+}
+
+// TestMachineRandom checks that a Machine agrees with Matches for
+// random patterns (which exercise every kind of index) and random
+// messages, including after patterns are removed.
+func TestMachineRandom(t *testing.T) {
+	var (
+		r     = rand.New(rand.NewSource(42))
+		paths = []string{"a", "b", "c"}
+		leafs = []string{
+			`"x"`, `"xy"`, `1`, `2.5`, `null`, `true`,
+			`{"prefix":""}`, `{"prefix":"x"}`, `{"prefix":"xy"}`,
+			`{"numeric":[">",1]}`, `{"numeric":[">=",1,"<",3]}`, `{"numeric":["=",2.5]}`,
+			`{"numeric":["<=",1]}`, `{"numeric":[">",0,"<=",2.5]}`,
+			`{"exists":true}`, `{"exists":false}`,
+			`{"suffix":"y"}`, `{"anything-but":"x"}`,
+		}
+		values = []string{
+			`"x"`, `"xy"`, `"y"`, `""`, `0`, `1`, `2`, `2.5`, `3`, `null`, `true`,
+			`[]`, `{}`, `["xy",3]`, `[1,null]`, `{"d":"x"}`,
+		}
+		pats = make(map[string]Constraint)
+		m    = NewMachine()
+	)
+
+	pattern := func() string {
+		acc := "{"
+		for i, p := range r.Perm(len(paths))[:1+r.Intn(len(paths))] {
+			if 0 < i {
+				acc += ","
+			}
+			acc += `"` + paths[p] + `":[`
+			for j := 0; j <= r.Intn(2); j++ {
+				if 0 < j {
+					acc += ","
+				}
+				acc += leafs[r.Intn(len(leafs))]
+			}
+			acc += "]"
+		}
+		return acc + "}"
+	}
+
+	message := func() string {
+		acc := "{"
+		for i, p := range r.Perm(len(paths))[:r.Intn(len(paths)+1)] {
+			if 0 < i {
+				acc += ","
+			}
+			acc += `"` + paths[p] + `":` + values[r.Intn(len(values))]
+		}
+		return acc + "}"
+	}
+
+	check := func() {
+		for i := 0; i < 200; i++ {
+			msg := P(message())
+			want := []string{}
+			for id, c := range pats {
+				if ok, _ := c.Matches(msg); ok {
+					want = append(want, id)
+				}
+			}
+			sort.Strings(want)
+			if got := m.Matches(msg); !reflect.DeepEqual(want, got) {
+				t.Fatalf("%s: want %v, got %v", JSON(msg), want, got)
+			}
+		}
+	}
+
+	for i := 0; i < 300; i++ {
+		js := pattern()
+		c, err := ParsePattern(P(js))
+		if err != nil {
+			t.Fatal(js, err)
+		}
+		id := strconv.Itoa(i)
+		pats[id] = c
+		m.Add(id, c)
+	}
+	check()
+
+	for i := 0; i < 300; i += 2 {
+		id := strconv.Itoa(i)
+		delete(pats, id)
+		m.Remove(id)
+	}
+	check()
+}
+
+func BenchmarkMachine(b *testing.B) {
+	m := NewMachine()
+	for i := 0; i < 5000; i++ {
+		js := `{"want":["` + strconv.Itoa(i) + `"],"price":[{"numeric":["<",` + strconv.Itoa(i) + `]}]}`
+		c, err := ParsePattern(P(js))
+		if err != nil {
+			b.Fatal(err)
+		}
+		m.Add(strconv.Itoa(i), c)
+	}
+	msg := P(`{"want":"42","price":10,"other":{"stuff":[1,2,3]}}`)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if ids := m.Matches(msg); len(ids) != 1 {
+			b.Fatal(ids)
+		}
+	}
+}
+
+// BenchmarkMachineLongString shows that the cost of matching a
+// string against prefixes doesn't grow with the square of the
+// string's length.
+func BenchmarkMachineLongString(b *testing.B) {
+	m := NewMachine()
+	for i := 0; i < 20; i++ {
+		c, err := ParsePattern(P(`{"id":[{"prefix":"` + strconv.Itoa(i) + `-"}]}`))
+		if err != nil {
+			b.Fatal(err)
+		}
+		m.Add(strconv.Itoa(i), c)
+	}
+	for _, n := range []int{1000, 100000} {
+		msg := map[string]interface{}{
+			"id": "7-" + strings.Repeat("x", n),
+		}
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if ids := m.Matches(msg); len(ids) != 1 {
+					b.Fatal(ids)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkMachineIndexes shows that the cost of matching doesn't
+// grow with the number of prefix, numeric, or exists patterns, but it
+// does grow with the number of patterns that have no indexed clauses
+// (like "suffix" or "exists": false alone).
+func BenchmarkMachineIndexes(b *testing.B) {
+	kinds := map[string]func(i int) string{
+		"prefix": func(i int) string {
+			return `{"id":[{"prefix":"` + strconv.Itoa(i) + `-"}]}`
+		},
+		"numeric": func(i int) string {
+			return `{"price":[{"numeric":[">=",` + strconv.Itoa(i) + `,"<",` + strconv.Itoa(i+1) + `]}]}`
+		},
+		"exists": func(i int) string {
+			return `{"x` + strconv.Itoa(i) + `":[{"exists":true}]}`
+		},
+		"absent": func(i int) string {
+			return `{"id":[{"prefix":"` + strconv.Itoa(i) + `-"}],"y` + strconv.Itoa(i) + `":[{"exists":false}]}`
+		},
+		"unindexed": func(i int) string {
+			return `{"id":[{"suffix":"-` + strconv.Itoa(i) + `"}]}`
+		},
+	}
+	msg := P(`{"id":"42-42","price":42.5,"x42":1,"other":{"stuff":[1,2,3]}}`)
+
+	for _, kind := range []string{"prefix", "numeric", "exists", "absent", "unindexed"} {
+		for _, n := range []int{100, 10000} {
+			m := NewMachine()
+			for i := 0; i < n; i++ {
+				c, err := ParsePattern(P(kinds[kind](i)))
+				if err != nil {
+					b.Fatal(err)
+				}
+				m.Add(strconv.Itoa(i), c)
+			}
+			b.Run(kind+"/"+strconv.Itoa(n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if ids := m.Matches(msg); len(ids) != 1 {
+						b.Fatal(ids)
+					}
+				}
+			})
+		}
+	}
+}
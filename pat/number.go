@@ -0,0 +1,243 @@
+package pat
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// number is an exact representation of a numeric value of any Go
+// numeric type or a json.Number.
+type number struct {
+	kind numberKind
+	i    int64
+	u    uint64
+	f    float64
+	r    *big.Rat
+}
+
+type numberKind int
+
+const (
+	intNumber numberKind = iota
+	uintNumber
+	floatNumber
+	ratNumber
+)
+
+// toNumber converts the given value to a number if the value is a
+// number.  NaNs and infinities are not numbers.
+func toNumber(x interface{}) (number, bool) {
+	switch vv := x.(type) {
+	case float64:
+		return floatToNumber(vv)
+	case int:
+		return number{kind: intNumber, i: int64(vv)}, true
+	case int64:
+		return number{kind: intNumber, i: vv}, true
+	case uint64:
+		return number{kind: uintNumber, u: vv}, true
+	case json.Number:
+		return jsonNumber(vv)
+	case string, bool, nil, map[string]interface{}, []interface{}:
+		return number{}, false
+	}
+
+	v := reflect.ValueOf(x)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return number{kind: intNumber, i: v.Int()}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return number{kind: uintNumber, u: v.Uint()}, true
+	case reflect.Float32, reflect.Float64:
+		return floatToNumber(v.Float())
+	}
+
+	return number{}, false
+}
+
+func floatToNumber(f float64) (number, bool) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return number{}, false
+	}
+	return number{kind: floatNumber, f: f}, true
+}
+
+// maxExponent bounds the exponent of a json.Number (like the 400 in
+// 1e400).  Exact comparisons of numbers with bigger exponents get
+// expensive, so a pattern can't have them (see checkExponent), and
+// such a value in a message isn't a number.
+const maxExponent = 1000
+
+// exponentOK reports whether the number's exponent (if any) is
+// within maxExponent.
+func exponentOK(s string) bool {
+	i := strings.IndexAny(s, "eE")
+	if i < 0 {
+		return true
+	}
+	e, err := strconv.Atoi(s[i+1:])
+	return err == nil && -maxExponent <= e && e <= maxExponent
+}
+
+// checkExponent returns an error if the given value is a json.Number
+// with an exponent beyond maxExponent.
+func checkExponent(x interface{}) error {
+	if s, is := x.(json.Number); is && !exponentOK(string(s)) {
+		return fmt.Errorf("number '%s' has an exponent beyond %d", s, maxExponent)
+	}
+	return nil
+}
+
+// jsonNumber converts a json.Number.  Integers are exact, and other
+// numbers are float64s (as if decoded without UseNumber) unless they
+// are out of float64's range.  A number with an exponent beyond
+// maxExponent isn't a number.
+func jsonNumber(s json.Number) (number, bool) {
+	if !exponentOK(string(s)) {
+		return number{}, false
+	}
+	if i, err := strconv.ParseInt(string(s), 10, 64); err == nil {
+		return number{kind: intNumber, i: i}, true
+	}
+	if u, err := strconv.ParseUint(string(s), 10, 64); err == nil {
+		return number{kind: uintNumber, u: u}, true
+	}
+	if f, err := strconv.ParseFloat(string(s), 64); err == nil {
+		return floatToNumber(f)
+	}
+	r, ok := new(big.Rat).SetString(string(s))
+	if !ok {
+		return number{}, false
+	}
+	return number{kind: ratNumber, r: r}, true
+}
+
+// isNumber reports whether the given value is a number.
+func isNumber(x interface{}) bool {
+	_, ok := toNumber(x)
+	return ok
+}
+
+func (n number) rat() *big.Rat {
+	switch n.kind {
+	case intNumber:
+		return new(big.Rat).SetInt64(n.i)
+	case uintNumber:
+		return new(big.Rat).SetInt(new(big.Int).SetUint64(n.u))
+	case floatNumber:
+		return new(big.Rat).SetFloat64(n.f)
+	default:
+		return n.r
+	}
+}
+
+// cmp compares two numbers exactly.
+func (n number) cmp(m number) int {
+	switch {
+	case n.kind == intNumber && m.kind == intNumber:
+		switch {
+		case n.i < m.i:
+			return -1
+		case n.i > m.i:
+			return 1
+		}
+		return 0
+	case n.kind == uintNumber && m.kind == uintNumber:
+		switch {
+		case n.u < m.u:
+			return -1
+		case n.u > m.u:
+			return 1
+		}
+		return 0
+	case n.kind == floatNumber && m.kind == floatNumber:
+		switch {
+		case n.f < m.f:
+			return -1
+		case n.f > m.f:
+			return 1
+		}
+		return 0
+	}
+	return n.rat().Cmp(m.rat())
+}
+
+// compareNumbers compares two values exactly if they are both
+// numbers.
+func compareNumbers(x, y interface{}) (int, bool) {
+	n, ok := toNumber(x)
+	if !ok {
+		return 0, false
+	}
+	m, ok := toNumber(y)
+	if !ok {
+		return 0, false
+	}
+	return n.cmp(m), true
+}
+
+// text renders the number as JSON in a canonical form, so that equal
+// numbers of different types (like 3, 3.0, and json.Number("3e0"))
+// render the same.
+func (n number) text() string {
+	switch n.kind {
+	case intNumber:
+		return strconv.FormatInt(n.i, 10)
+	case uintNumber:
+		return strconv.FormatUint(n.u, 10)
+	case floatNumber:
+		if n.f == math.Trunc(n.f) && math.Abs(n.f) < 1<<62 {
+			return strconv.FormatInt(int64(n.f), 10)
+		}
+		return strconv.FormatFloat(n.f, 'g', -1, 64)
+	}
+	if n.r.IsInt() {
+		return n.r.Num().String()
+	}
+	// A decimal's denominator is 2^a 5^b, so max(a, b) digits
+	// after the point are exact.
+	var (
+		d    = new(big.Int).Set(n.r.Denom())
+		twos = d.TrailingZeroBits()
+		five = big.NewInt(5)
+		m    = new(big.Int)
+		q    = new(big.Int)
+	)
+	d.Rsh(d, twos)
+	fives := uint(0)
+	for {
+		q.QuoRem(d, five, m)
+		if m.Sign() != 0 {
+			break
+		}
+		d.Set(q)
+		fives++
+	}
+	if fives < twos {
+		fives = twos
+	}
+	return n.r.FloatString(int(fives))
+}
+
+// numberKey is a canonical key for a number, which allows equal
+// numbers of different types to share a map key.
+type numberKey string
+
+func (n number) key() numberKey {
+	switch n.kind {
+	case intNumber:
+		return numberKey(strconv.FormatInt(n.i, 10))
+	case uintNumber:
+		return numberKey(strconv.FormatUint(n.u, 10))
+	case floatNumber:
+		if n.f == math.Trunc(n.f) && math.Abs(n.f) < 1<<62 {
+			return numberKey(strconv.FormatInt(int64(n.f), 10))
+		}
+	}
+	return numberKey(n.rat().RatString())
+}
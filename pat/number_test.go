@@ -0,0 +1,179 @@
+package pat
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompareNumbers(t *testing.T) {
+	type ID int64
+
+	for _, tc := range []struct {
+		x, y interface{}
+		want int
+	}{
+		{int64(1<<53 + 1), float64(1 << 53), 1},
+		{float64(1 << 53), int64(1<<53 + 1), -1},
+		{json.Number("9007199254740993"), int64(1<<53 + 1), 0},
+		{json.Number("9007199254740993"), float64(1 << 53), 1},
+		{uint64(1<<64 - 1), int64(-1), 1},
+		{int8(-1), uint(0), -1},
+		{int32(7), float32(7), 0},
+		{ID(42), json.Number("42"), 0},
+		{json.Number("42.0"), 42, 0},
+		{json.Number("4.2e1"), uint16(42), 0},
+		{json.Number("0.1"), 0.1, 0},
+		{json.Number("-0.5"), 0, -1},
+		{json.Number("18446744073709551616"), uint64(1<<64 - 1), 1},
+		{uintptr(3), 2.5, 1},
+	} {
+		got, ok := compareNumbers(tc.x, tc.y)
+		if !ok {
+			t.Fatalf("%#v %#v", tc.x, tc.y)
+		}
+		if got != tc.want {
+			t.Fatalf("%#v %#v: %d", tc.x, tc.y, got)
+		}
+	}
+
+	for _, x := range []interface{}{"1", true, nil, json.Number("x"), []interface{}{1}} {
+		if _, ok := compareNumbers(x, 1); ok {
+			t.Fatalf("%#v", x)
+		}
+	}
+}
+
+func TestExactNumeric(t *testing.T) {
+	c, err := ParsePattern(map[string]interface{}{
+		"id": []interface{}{
+			map[string]interface{}{
+				"numeric": []interface{}{">", json.Number("9007199254740992")},
+			},
+		},
+		"n": []interface{}{int64(1<<53 + 1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		msg  interface{}
+		want bool
+	}{
+		{map[string]interface{}{"id": int64(1<<53 + 1), "n": uint64(1<<53 + 1)}, true},
+		{map[string]interface{}{"id": json.Number("9007199254740993"), "n": json.Number("9007199254740993")}, true},
+		{map[string]interface{}{"id": int64(1 << 53), "n": uint64(1<<53 + 1)}, false},
+		{map[string]interface{}{"id": int64(1<<53 + 1), "n": float64(1 << 53)}, false},
+		{map[string]interface{}{"id": int32(1), "n": uint64(1<<53 + 1)}, false},
+	} {
+		got, err := c.Matches(tc.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Fatalf("%#v", tc.msg)
+		}
+
+		// The Machine indexes numbers, so check it, too.
+		m := NewMachine()
+		m.Add("c", c)
+		if ids := m.Matches(tc.msg); (len(ids) == 1) != tc.want {
+			t.Fatalf("Machine %#v: %v", tc.msg, ids)
+		}
+	}
+}
+
+func TestNumberText(t *testing.T) {
+	for x, want := range map[interface{}]string{
+		3:                                      "3",
+		3.0:                                    "3",
+		json.Number("3e0"):                     "3",
+		json.Number("0.50"):                    "0.5",
+		uint64(1<<64 - 1):                      "18446744073709551615",
+		1e21:                                   "1e+21",
+		json.Number("1.5e400"):                 "15" + strings.Repeat("0", 399),
+		json.Number("15e399"):                  "15" + strings.Repeat("0", 399),
+		json.Number("-1e309"):                  "-1" + strings.Repeat("0", 309),
+		"1" + strings.Repeat("0", 309) + ".25": "1" + strings.Repeat("0", 309) + ".25",
+	} {
+		if s, is := x.(string); is {
+			x = json.Number(s)
+		}
+		n, ok := toNumber(x)
+		if !ok {
+			t.Fatalf("%#v", x)
+		}
+		if got := n.text(); got != want {
+			t.Fatalf("%#v: want %s, got %s", x, want, got)
+		}
+	}
+}
+
+// TestExponent checks the edges of maxExponent in patterns and in
+// messages.
+func TestExponent(t *testing.T) {
+	decode := func(js string) interface{} {
+		x, err := decodeSelection([]byte(js), everything)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return x
+	}
+
+	for js, ok := range map[string]bool{
+		`{"n":[{"numeric":[">",1e1000]}]}`:        true,
+		`{"n":[{"numeric":[">",1e-1000]}]}`:       true,
+		`{"n":[1E+1000]}`:                         true,
+		`{"n":[{"numeric":[">",1e1001]}]}`:        false,
+		`{"n":[{"numeric":[">",1e-1001]}]}`:       false,
+		`{"n":[{"anything-but":[1e10000000]}]}`:   false,
+		`{"n":[1e99999999999999999999999999999]}`: false,
+	} {
+		_, err := ParsePattern(decode(js))
+		if ok != (err == nil) {
+			t.Fatalf("%s: %v", js, err)
+		}
+		var pe *ParseError
+		if !ok && (!errors.As(err, &pe) || pe.Code != CodeValue) {
+			t.Fatalf("%s: %#v", js, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		pat, msg string
+		want     bool
+	}{
+		{`{"n":[{"numeric":[">",1]}]}`, `{"n":1e1000}`, true},
+		{`{"n":[{"numeric":["<",1]}]}`, `{"n":1e1000}`, false},
+		{`{"n":[{"anything-but":5}]}`, `{"n":1e1000}`, true},
+		{`{"n":[{"numeric":[">",1]}]}`, `{"n":1e1001}`, false},
+		{`{"n":[{"numeric":["<",1]}]}`, `{"n":1e1001}`, false},
+		{`{"n":[{"anything-but":5}]}`, `{"n":1e10000000}`, false},
+		{`{"n":[5]}`, `{"n":1e-1001}`, false},
+	} {
+		c, err := ParsePattern(decode(tc.pat))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := decode(tc.msg)
+		if got, err := c.Matches(msg); got != tc.want || err != nil {
+			t.Fatalf("%s %s: %v %v", tc.pat, tc.msg, got, err)
+		}
+		m := NewMachine()
+		m.Add("c", c)
+		if ids := m.Matches(msg); (len(ids) == 1) != tc.want {
+			t.Fatalf("Machine %s %s: %v", tc.pat, tc.msg, ids)
+		}
+
+		// A leaf's own check reports an out-of-range value.
+		var (
+			leaf = c.(Map)["n"].(Constraints)[0]
+			n    = msg.(map[string]interface{})["n"]
+		)
+		if _, err := leaf.Matches(n); (err == nil) != (checkExponent(n) == nil) {
+			t.Fatalf("%s %s: %v", tc.pat, tc.msg, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package pat
+
+// Or is the disjunction that "$or" introduces.
+//
+// In a Map, an Or appears at the key "$or", and it's matched against
+// the map that contains that key (rather than against a value at
+// that key).  Each element of an Or is usually a Map.
+type Or []Constraint
+
+// Matches reports whether any of the disjuncts match.
+func (c Or) Matches(x interface{}) (bool, error) {
+	for _, d := range c {
+		ok, err := d.Matches(x)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DefaultMaxCombinations is EventBridge's limit on the number of
+// combinations a pattern with "$or" can expand to.
+const DefaultMaxCombinations = 1000
+
+func (cfg *Cfg) parseOr(x interface{}, path string) (Or, error) {
+	xs, is := x.([]interface{})
+	if !is || len(xs) == 0 {
+		return nil, parseError(path, CodeType, x, "bad $or '%#v'", x)
+	}
+	o := make(Or, len(xs))
+	for i, y := range xs {
+		if _, is := y.(map[string]interface{}); !is {
+			return nil, parseError(pathIndex(path, i), CodeType, y, "bad $or element '%#v'", y)
+		}
+		c, err := cfg.parsePattern(y, pathIndex(path, i))
+		if err != nil {
+			return nil, err
+		}
+		o[i] = c
+	}
+	return o, nil
+}
+
+// Combinations returns the number of conjunctive patterns that the
+// given pattern expands to when each "$or" is distributed over its
+// siblings.  A pattern without any "$or" has one combination.
+//
+// The count saturates at a large value rather than overflowing.
+func Combinations(x interface{}) int {
+	const max = 1 << 30
+	switch vv := x.(type) {
+	case Map:
+		n := 1
+		for _, v := range vv {
+			n *= Combinations(v)
+			if max < n {
+				n = max
+			}
+		}
+		return n
+	case Or:
+		n := 0
+		for _, d := range vv {
+			n += Combinations(d)
+			if max < n {
+				n = max
+			}
+		}
+		return n
+	default:
+		return 1
+	}
+}
+
+// leaf is a pattern at a path.
+type leaf struct {
+	path []string
+	pat  interface{}
+}
+
+// alternatives expands the given Map, which is at the given path,
+// into its disjuncts, each of which is a conjunction of leaves.
+//
+// See Combinations.
+func alternatives(p Map, path []string) [][]leaf {
+	acc := [][]leaf{nil}
+	for k, v := range p {
+		var alts [][]leaf
+		switch vv := v.(type) {
+		case Or:
+			for _, d := range vv {
+				m, is := d.(Map)
+				if !is {
+					// Just treat the whole disjunct as
+					// a leaf at this path.
+					alts = append(alts, []leaf{{path, d}})
+					continue
+				}
+				alts = append(alts, alternatives(m, path)...)
+			}
+		case Map:
+			if 0 < len(vv) {
+				alts = alternatives(vv, extend(path, k))
+				break
+			}
+			alts = [][]leaf{{{extend(path, k), v}}}
+		default:
+			alts = [][]leaf{{{extend(path, k), v}}}
+		}
+		product := make([][]leaf, 0, len(acc)*len(alts))
+		for _, ls := range acc {
+			for _, alt := range alts {
+				conj := make([]leaf, 0, len(ls)+len(alt))
+				conj = append(conj, ls...)
+				conj = append(conj, alt...)
+				product = append(product, conj)
+			}
+		}
+		acc = product
+	}
+	return acc
+}
+
+func extend(path []string, k string) []string {
+	acc := make([]string, len(path), len(path)+1)
+	copy(acc, path)
+	return append(acc, k)
+}
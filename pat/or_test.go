@@ -0,0 +1,64 @@
+package pat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCombinations(t *testing.T) {
+	try := func(js string, want int) {
+		t.Run(js, func(t *testing.T) {
+			c, err := (&Cfg{}).ParsePattern(P(js))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Combinations(c); got != want {
+				t.Fatal(got)
+			}
+		})
+	}
+
+	try(`{"a":[1]}`, 1)
+	try(`{"$or":[{"a":[1]},{"b":[2]}]}`, 2)
+	try(`{"$or":[{"a":[1]},{"b":[2]}],"c":{"$or":[{"d":[1]},{"e":[1]},{"f":[1]}]}}`, 6)
+	try(`{"$or":[{"a":[1]},{"$or":[{"b":[2]},{"c":[3]}]}]}`, 3)
+}
+
+func TestMaxCombinations(t *testing.T) {
+	// 2*2*2*2*2*2*2*2*2*2 = 1024 > 1000
+	or := `{"$or":[{"a":[1]},{"b":[2]}]}`
+	js := `{"k0":` + or
+	for i := 1; i < 10; i++ {
+		js += `,"k` + string(rune('0'+i)) + `":` + or
+	}
+	js += `}`
+
+	if _, err := ParsePattern(P(js)); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if _, err := (&Cfg{}).ParsePattern(P(js)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMachineOr(t *testing.T) {
+	m := NewMachine()
+
+	c, err := ParsePattern(P(`{"source":["shop"],"$or":[{"detail":{"total":[{"numeric":[">",100]}]}},{"detail":{"vip":[true]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Add("big", c)
+
+	// TestMachineAgrees covers matching.  Removing a pattern also
+	// removes each of its combinations.
+	msg := P(`{"source":"shop","detail":{"total":200}}`)
+	if got := m.Matches(msg); !reflect.DeepEqual(got, []string{"big"}) {
+		t.Fatal(got)
+	}
+	m.Remove("big")
+	if got := m.Matches(msg); len(got) != 0 {
+		t.Fatal(got)
+	}
+}
@@ -12,10 +12,60 @@ type Constraint interface {
 }
 
 // Cfg can store limits and options for parsing patterns.
+//
+// Each limit applies only if it's positive.  A pattern that exceeds
+// a limit results in a *LimitError.
 type Cfg struct {
+	// MaxCombinations is the maximum number of combinations that
+	// a pattern's "$or"s can expand to.
+	//
+	// See Combinations.
+	MaxCombinations int
+
+	// MaxDepth is the maximum nesting depth of a pattern's
+	// objects and arrays.  The top level has depth 1.
+	MaxDepth int
+
+	// MaxFields is the maximum total number of object fields in
+	// a pattern (including operators like "prefix").
+	MaxFields int
+
+	// MaxArrayLength is the maximum length of any array in a
+	// pattern.
+	MaxArrayLength int
+
+	// MaxStringLength is the maximum length in bytes of any
+	// string (including any object key) in a pattern.
+	MaxStringLength int
+
+	// MaxLeaves is the maximum total number of strings, numbers,
+	// booleans, and nulls in a pattern.
+	MaxLeaves int
+
+	// Strict rejects patterns that use this package's extensions
+	// to EventBridge patterns.  Those extensions are a pattern
+	// that isn't an object, a leaf that's a literal rather than an
+	// array, and an array element that's neither a literal nor an
+	// object with a known operator.
+	//
+	// A pattern that parses in strict mode should be acceptable to
+	// EventBridge.
+	Strict bool
+
+	// Arrays enables the array operators "element" and "length",
+	// which are extensions to EventBridge patterns.  See Element
+	// and Length.  Strict mode rejects them.
+	Arrays bool
 }
 
-var DefaultCfg = &Cfg{}
+var DefaultCfg = &Cfg{
+	MaxCombinations: DefaultMaxCombinations,
+	MaxDepth:        DefaultMaxDepth,
+	MaxFields:       DefaultMaxFields,
+	MaxArrayLength:  DefaultMaxArrayLength,
+	MaxStringLength: DefaultMaxStringLength,
+	MaxLeaves:       DefaultMaxLeaves,
+}
 
 // ParsePattern just calls DefaultCfg.ParsePattern().
 //
@@ -29,66 +79,47 @@ func ParsePattern(x interface{}) (Constraint, error) {
 //
 // This function is called recursively on it's first argument.  When
 // that first argument is a Constraint, the the Matches method of that
-// interface is called.  Otherwise, the match check is mostly literal.
+// interface is called.  Otherwise, the match check is literal for
+// strings, bools, and null, and numbers of different types are
+// compared exactly.
 func Matches(pat, y interface{}) (bool, error) {
 	switch v1 := pat.(type) {
 	case Constraint:
 		return v1.Matches(y)
 	case map[string]interface{}:
-		switch v2 := y.(type) {
-		case map[string]interface{}:
-			if len(v1) == 0 {
-				return true, nil
-			}
-			for k, x := range v1 {
-				if z, have := v2[k]; have {
-					if c, is := x.(*Exists); is {
-						return c.Value, nil
-					}
-					ok, err := Matches(x, z)
-					if err != nil {
-						return false, err
-					}
-					return ok, nil
-				}
-				if c, is := x.(*Exists); is {
-					return !c.Value, nil
-				}
-				return false, nil
-			}
-		default:
-			return false, nil
-		}
+		return Map(v1).Matches(y)
 
 	case string:
 		switch v2 := y.(type) {
 		case string:
 			return v1 == v2, nil
 		}
-	case int:
+	case bool:
 		switch v2 := y.(type) {
-		case int:
+		case bool:
 			return v1 == v2, nil
-		case int64:
-			return int64(v1) == v2, nil
 		}
-	case int64:
-		switch v2 := y.(type) {
-		case int:
-			return v1 == int64(v2), nil
-		case int64:
-			return v1 == v2, nil
+	case nil:
+		return y == nil, nil
+	default:
+		if c, ok := compareNumbers(v1, y); ok {
+			return c == 0, nil
 		}
-	case float64:
-		switch v2 := y.(type) {
-		case float64:
-			return v1 == v2, nil
+		if isNumber(v1) {
+			return false, checkExponent(y)
 		}
 	}
 
 	return false, nil
 }
 
+// wholeValue is a Constraint in a Constraints that's about an entire
+// value rather than each element of an array (like Exists).
+type wholeValue interface {
+	Constraint
+	wholeValue()
+}
+
 // Constraints is just a list of Constraints, and a Constraints is
 // itself a Constraint.
 type Constraints []Constraint
@@ -107,8 +138,19 @@ func (cs Constraints) Matches(x interface{}) (bool, error) {
 		xs = []interface{}{x}
 	}
 
+	for _, c := range cs {
+		if _, is := c.(wholeValue); is {
+			if ok, err := c.Matches(x); ok && err == nil {
+				return true, nil
+			}
+		}
+	}
+
 	for _, x := range xs {
 		for _, c := range cs {
+			if _, is := c.(wholeValue); is {
+				continue
+			}
 			if ok, err := c.Matches(x); ok && err == nil {
 				// Drop errors?
 				return true, nil
@@ -119,38 +161,103 @@ func (cs Constraints) Matches(x interface{}) (bool, error) {
 	return false, nil
 }
 
-func (cfg *Cfg) parseConstraint(x interface{}) (Constraint, error) {
+// parseConstraint parses an element of an array of constraints at
+// the given path.
+func (cfg *Cfg) parseConstraint(x interface{}, path string) (Constraint, error) {
 	switch vv := x.(type) {
 	default:
 		return &Literal{
 			Value: x,
 		}, nil
+	case []interface{}:
+		if cfg.Strict {
+			return nil, parseError(path, CodeStrict, x, "strict: nested array '%#v'", x)
+		}
+		return &Literal{
+			Value: x,
+		}, nil
 	case map[string]interface{}:
 
+		if 1 < len(vv) {
+			for _, k := range sortedKeys(vv) {
+				if cfg.isOperator(k) {
+					return nil, parseError(path, CodeOperator, x, "operator '%s' with other properties in '%#v'", k, x)
+				}
+			}
+		}
+
 		if y, have := vv["anything-but"]; have {
-			a, is := y.([]interface{})
+			return cfg.parseAnythingBut(y, pathField(path, "anything-but"))
+		}
+
+		if y, have := vv["prefix"]; have {
+			s, is := y.(string)
 			if !is {
-				return nil, fmt.Errorf("bad anything-but argument '%#v' (%T)", y, y)
+				return nil, parseError(pathField(path, "prefix"), CodeType, y, "bad prefix '%#v'", y)
 			}
-			return &AnythingBut{
-				Value: a,
+			return &Prefix{
+				Value: s,
 			}, nil
 		}
 
-		if y, have := vv["prefix"]; have {
+		if y, have := vv["suffix"]; have {
 			s, is := y.(string)
 			if !is {
-				return nil, fmt.Errorf("bad prefix '%#v'", y)
+				return nil, parseError(pathField(path, "suffix"), CodeType, y, "bad suffix '%#v'", y)
 			}
-			return &Prefix{
+			return &Suffix{
 				Value: s,
 			}, nil
 		}
 
+		if y, have := vv["equals-ignore-case"]; have {
+			s, is := y.(string)
+			if !is {
+				return nil, parseError(pathField(path, "equals-ignore-case"), CodeType, y, "bad equals-ignore-case '%#v'", y)
+			}
+			return &EqualsIgnoreCase{
+				Value: s,
+			}, nil
+		}
+
+		if y, have := vv["wildcard"]; have {
+			s, is := y.(string)
+			if !is {
+				return nil, parseError(pathField(path, "wildcard"), CodeType, y, "bad wildcard '%#v'", y)
+			}
+			c, err := NewWildcard(s)
+			if err != nil {
+				return nil, &ParseError{
+					Path:  pathField(path, "wildcard"),
+					Value: y,
+					Code:  CodeValue,
+					Err:   err,
+				}
+			}
+			return c, nil
+		}
+
+		if y, have := vv["cidr"]; have {
+			s, is := y.(string)
+			if !is {
+				return nil, parseError(pathField(path, "cidr"), CodeType, y, "bad cidr '%#v'", y)
+			}
+			c, err := NewCIDR(s)
+			if err != nil {
+				return nil, &ParseError{
+					Path:  pathField(path, "cidr"),
+					Value: y,
+					Code:  CodeValue,
+					Err:   err,
+				}
+			}
+			return c, nil
+		}
+
 		if y, have := vv["exists"]; have {
 			b, is := y.(bool)
 			if !is {
-				return nil, fmt.Errorf("bad exists value '%#v'", y)
+				return nil, parseError(pathField(path, "exists"), CodeType, y, "bad exists value '%#v'", y)
 			}
 			return &Exists{
 				Value: b,
@@ -158,52 +265,185 @@ func (cfg *Cfg) parseConstraint(x interface{}) (Constraint, error) {
 		}
 
 		if y, have := vv["numeric"]; have {
-			ys, is := y.([]interface{})
-			if !is {
-				return nil, fmt.Errorf("bad numeric '%#v'", y)
+			return cfg.parseNumeric(y, pathField(path, "numeric"))
+		}
+
+		if c, err := cfg.parseArrayOperator(vv, path); c != nil || err != nil {
+			return c, err
+		}
+
+		if cfg.Strict {
+			return nil, parseError(path, CodeStrict, x, "strict: unknown operator in '%#v'", x)
+		}
+
+		return Map(vv), nil
+	}
+}
+
+// isOperator reports whether the given property names an operator
+// (like "prefix") that parseConstraint recognizes.  An operator must
+// be the only property of its object.
+func (cfg *Cfg) isOperator(k string) bool {
+	switch k {
+	case "anything-but", "prefix", "suffix", "equals-ignore-case", "wildcard", "cidr", "exists", "numeric":
+		return true
+	case "element", "length":
+		return cfg.Arrays && !cfg.Strict
+	}
+	return false
+}
+
+// parseAnythingBut parses the argument of "anything-but", which can
+// be a string, a number, a non-empty array of those (or, unless
+// strict, of booleans and nulls), or an object with a
+// single string operator ("prefix", "suffix", "equals-ignore-case",
+// or "wildcard").
+func (cfg *Cfg) parseAnythingBut(x interface{}, path string) (Constraint, error) {
+	switch vv := x.(type) {
+	case string:
+		return &AnythingBut{
+			Value: []interface{}{vv},
+		}, nil
+	case []interface{}:
+		if len(vv) == 0 {
+			return nil, parseError(path, CodeValue, x, "empty anything-but array")
+		}
+		for i, y := range vv {
+			switch y.(type) {
+			case string:
+				continue
+			case bool, nil:
+				if cfg.Strict {
+					return nil, parseError(pathIndex(path, i), CodeStrict, y, "strict: anything-but value '%#v'", y)
+				}
+				continue
 			}
-			if len(ys)%2 != 0 {
-				return nil, fmt.Errorf("bad numeric array size '%#v'", ys)
+			if !isNumber(y) {
+				return nil, parseError(pathIndex(path, i), CodeType, y, "bad anything-but value '%#v' (%T)", y, y)
 			}
-			ns := &Numeric{
-				Predicates: make([]NumericPredicate, 0, len(ys)/2),
+		}
+		return &AnythingBut{
+			Value: vv,
+		}, nil
+	case map[string]interface{}:
+		if len(vv) != 1 {
+			break
+		}
+		for op, y := range vv {
+			switch op {
+			case "prefix", "suffix", "equals-ignore-case", "wildcard":
+			default:
+				return nil, parseError(pathField(path, op), CodeOperator, y, "bad anything-but operator '%s'", op)
 			}
-			for i := 0; i < len(ys); i += 2 {
-				s, is := ys[i].(string)
-				if !is {
-					return nil, fmt.Errorf("bad numeric relation '%#v'", ys[i])
-				}
-				p := NumericPredicate{
-					Relation: s,
-				}
-				switch vv := ys[i+1].(type) {
-				case int:
-					p.Value = float64(vv)
-				case float64:
-					p.Value = vv
-				case int64:
-					p.Value = float64(vv)
-				default:
-					return nil, fmt.Errorf("bad numeric relation value '%#v'", ys[i+1])
-				}
-				ns.Predicates = append(ns.Predicates, p)
+			if _, is := y.(string); !is {
+				return nil, parseError(pathField(path, op), CodeType, y, "bad anything-but %s '%#v'", op, y)
 			}
-			return ns, nil
+			c, err := cfg.parseConstraint(vv, path)
+			if err != nil {
+				return nil, err
+			}
+			return &AnythingBut{
+				Constraint: c,
+			}, nil
+		}
+	default:
+		if isNumber(x) {
+			return &AnythingBut{
+				Value: []interface{}{x},
+			}, nil
 		}
+	}
+	return nil, parseError(path, CodeType, x, "bad anything-but argument '%#v' (%T)", x, x)
+}
 
-		return Map(vv), nil
+// parseNumeric parses the argument of "numeric" into an interval.
+//
+// As with EventBridge, the argument is either ["=", N], a single
+// bound, or a lower bound and an upper bound (in either order).  An
+// empty interval is an error.
+func (cfg *Cfg) parseNumeric(x interface{}, path string) (*Numeric, error) {
+	ys, is := x.([]interface{})
+	if !is {
+		return nil, parseError(path, CodeType, x, "bad numeric '%#v'", x)
+	}
+	if len(ys) == 0 || len(ys)%2 != 0 {
+		return nil, parseError(path, CodeValue, x, "bad numeric array size %d (%#v)", len(ys), ys)
+	}
+	c := &Numeric{}
+	for i := 0; i < len(ys); i += 2 {
+		rel, is := ys[i].(string)
+		if !is {
+			return nil, parseError(pathIndex(path, i), CodeType, ys[i], "bad numeric relation '%#v'", ys[i])
+		}
+		if !isNumber(ys[i+1]) {
+			return nil, parseError(pathIndex(path, i+1), CodeType, ys[i+1], "bad numeric relation value '%#v'", ys[i+1])
+		}
+		p := &NumericPredicate{
+			Relation: rel,
+			Value:    ys[i+1],
+		}
+		switch rel {
+		case "=":
+			if len(ys) != 2 {
+				return nil, parseError(pathIndex(path, i), CodeRange, x, "numeric '=' can't be combined with other relations (%#v)", ys)
+			}
+			c.Lower = &NumericPredicate{Relation: ">=", Value: p.Value}
+			c.Upper = &NumericPredicate{Relation: "<=", Value: p.Value}
+		case ">", ">=":
+			if c.Lower != nil {
+				return nil, parseError(pathIndex(path, i), CodeRange, x, "more than one numeric lower bound (%#v)", ys)
+			}
+			c.Lower = p
+		case "<", "<=":
+			if c.Upper != nil {
+				return nil, parseError(pathIndex(path, i), CodeRange, x, "more than one numeric upper bound (%#v)", ys)
+			}
+			c.Upper = p
+		default:
+			return nil, parseError(pathIndex(path, i), CodeOperator, rel, "unknown numeric relation '%s'", rel)
+		}
+	}
+	if c.Empty() {
+		return nil, parseError(path, CodeRange, x, "empty numeric range (%#v)", ys)
 	}
+	return c, nil
 }
 
 // ParsePattern parses a Constraint from a plain value.
 //
+// An error is a *ParseError, which wraps a *LimitError if the
+// pattern exceeds one of the Cfg's limits.
+//
 // ToDo: Fix name of function or name of return type.
 func (cfg *Cfg) ParsePattern(x interface{}) (Constraint, error) {
+	if _, is := x.(map[string]interface{}); cfg.Strict && !is {
+		return nil, parseError("", CodeStrict, x, "strict: pattern '%#v' isn't an object", x)
+	}
+	if err := cfg.checkLimits(x); err != nil {
+		return nil, err
+	}
+	c, err := cfg.parsePattern(x, "")
+	if err != nil {
+		return nil, err
+	}
+	if 0 < cfg.MaxCombinations {
+		if n := Combinations(c); cfg.MaxCombinations < n {
+			return nil, &ParseError{
+				Value: x,
+				Code:  CodeLimit,
+				Err:   &LimitError{"MaxCombinations", cfg.MaxCombinations, n},
+			}
+		}
+	}
+	return c, nil
+}
+
+func (cfg *Cfg) parsePattern(x interface{}, path string) (Constraint, error) {
 	switch vv := x.(type) {
 	case []interface{}:
 		cs := make(Constraints, len(vv))
 		for i, v := range vv {
-			c, err := cfg.parseConstraint(v)
+			c, err := cfg.parseConstraint(v, pathIndex(path, i))
 			if err != nil {
 				return nil, err
 			}
@@ -212,8 +452,24 @@ func (cfg *Cfg) ParsePattern(x interface{}) (Constraint, error) {
 		return cs, nil
 	case map[string]interface{}:
 		m := make(Map, len(vv))
-		for k, v := range vv {
-			c, err := cfg.ParsePattern(v)
+		for _, k := range sortedKeys(vv) {
+			v := vv[k]
+			if k == "$or" {
+				o, err := cfg.parseOr(v, pathField(path, k))
+				if err != nil {
+					return nil, err
+				}
+				m[k] = o
+				continue
+			}
+			if cfg.Strict {
+				switch v.(type) {
+				case []interface{}, map[string]interface{}:
+				default:
+					return nil, parseError(pathField(path, k), CodeStrict, v, "strict: literal leaf '%#v' at '%s'", v, k)
+				}
+			}
+			c, err := cfg.parsePattern(v, pathField(path, k))
 			if err != nil {
 				return nil, err
 			}
@@ -243,33 +499,53 @@ var Pass = &pass{}
 
 type Map map[string]interface{}
 
+// Matches reports whether every key in the Map matches.
 func (c Map) Matches(x interface{}) (bool, error) {
 	m, is := x.(map[string]interface{})
 	if !is {
 		return false, nil
 	}
-	if 0 == len(c) {
-		return true, nil
-	}
 	for p, v1 := range c {
-		if v2, have := m[p]; have {
-			if pc, is := v1.(*Exists); is {
-				return pc.Value, nil
-			}
-			return Matches(v1, v2)
-		}
-		if pc, is := v1.(*Exists); is {
-			return !pc.Value, nil
-		}
-		if pc, is := v1.(Constraints); is {
-			return pc.Matches(Missing)
+		ok, err := c.matches(m, p, v1)
+		if err != nil || !ok {
+			return false, err
 		}
-		return false, nil
 	}
 
 	return true, nil
 }
 
+// matches checks a single key of the Map.
+func (c Map) matches(m map[string]interface{}, p string, v1 interface{}) (bool, error) {
+	if o, is := v1.(Or); is {
+		return o.Matches(m)
+	}
+	v2, have := m[p]
+	if sub, is := v1.(Map); is && 0 < len(sub) {
+		// As with EventBridge, which sees patterns and messages
+		// as paths to leaves, a missing value or a leaf has no
+		// values at the nested paths.  Arrays (even of objects)
+		// aren't traversed here (or by a Machine), so an array
+		// also has no values at the nested paths.
+		if _, is := v2.(map[string]interface{}); !is {
+			return sub.Matches(map[string]interface{}{})
+		}
+	}
+	if have {
+		if pc, is := v1.(*Exists); is {
+			return pc.Matches(v2)
+		}
+		return Matches(v1, v2)
+	}
+	if pc, is := v1.(*Exists); is {
+		return pc.Matches(Missing)
+	}
+	if pc, is := v1.(Constraints); is {
+		return pc.Matches(Missing)
+	}
+	return false, nil
+}
+
 type Literal struct {
 	Value interface{}
 }
@@ -278,11 +554,35 @@ func (c *Literal) Matches(x interface{}) (bool, error) {
 	return Matches(c.Value, x)
 }
 
+// AnythingBut matches a leaf (a string, number, boolean, or null)
+// that doesn't match any of its Values.  A missing value, an
+// object, or an empty array doesn't match.
 type AnythingBut struct {
 	Value []interface{}
+
+	// Constraint, if not nil, is a nested string constraint (a
+	// Prefix, Suffix, EqualsIgnoreCase, or Wildcard) that a string
+	// must not match.  Values that aren't
+	// strings do not match.
+	Constraint Constraint
 }
 
 func (c *AnythingBut) Matches(x interface{}) (bool, error) {
+	if c.Constraint != nil {
+		if _, is := x.(string); !is {
+			return false, nil
+		}
+		ok, err := c.Constraint.Matches(x)
+		if err != nil {
+			return false, err
+		}
+		return !ok, nil
+	}
+	if !isScalar(x) {
+		// As with EventBridge, anything-but only matches a
+		// leaf, so not a missing value or an object.
+		return false, nil
+	}
 	for _, y := range c.Value {
 		ok, err := Matches(y, x)
 		if err != nil {
@@ -307,68 +607,143 @@ func (c *Prefix) Matches(x interface{}) (bool, error) {
 	return strings.HasPrefix(s, c.Value), nil
 }
 
-type Numeric struct {
-	Predicates []NumericPredicate
+type Suffix struct {
+	Value string
 }
 
-type NumericPredicate struct {
-	Relation string
-	Value    float64
+func (c *Suffix) Matches(x interface{}) (bool, error) {
+	s, is := x.(string)
+	if !is {
+		return false, nil
+	}
+	return strings.HasSuffix(s, c.Value), nil
 }
 
-func toNumber(x interface{}) (float64, error) {
-	switch vv := x.(type) {
-	case float64:
-		return vv, nil
-	case int:
-		return float64(vv), nil
-	case int64:
-		return float64(vv), nil
-	case uint64:
-		return float64(vv), nil
+// EqualsIgnoreCase matches strings that are equal to its Value under
+// Unicode case folding.
+type EqualsIgnoreCase struct {
+	Value string
+}
+
+func (c *EqualsIgnoreCase) Matches(x interface{}) (bool, error) {
+	s, is := x.(string)
+	if !is {
+		return false, nil
 	}
-	return 0, fmt.Errorf("bad number %#v", x)
+	return strings.EqualFold(s, c.Value), nil
+}
+
+// Numeric is an interval of numbers.  Either bound can be nil, which
+// means that the interval is unbounded on that side.  An "=" is
+// represented as inclusive bounds with the same value.
+type Numeric struct {
+	// Lower is a ">" or ">=" predicate.
+	Lower *NumericPredicate
 
+	// Upper is a "<" or "<=" predicate.
+	Upper *NumericPredicate
+}
+
+// Numeric values can be of any Go numeric type or json.Number, and
+// comparisons are exact.  For example, int64(1<<53+1) is greater than
+// float64(1<<53).
+type NumericPredicate struct {
+	Relation string
+	Value    interface{}
 }
 
 func (c *NumericPredicate) Matches(msg interface{}) (bool, error) {
-	x, err := toNumber(msg)
-	if err != nil {
-		return false, nil
+	n, ok := compareNumbers(msg, c.Value)
+	if !ok {
+		if !isNumber(c.Value) {
+			return false, fmt.Errorf("bad numeric value '%#v'", c.Value)
+		}
+		return false, checkExponent(msg)
 	}
 	switch c.Relation {
 	default:
 		return false, fmt.Errorf("unknown numeric relation '%s'", c.Relation)
 	case "<":
-		return x < c.Value, nil
+		return n < 0, nil
 	case "<=":
-		return x <= c.Value, nil
+		return n <= 0, nil
 	case ">":
-		return x > c.Value, nil
+		return n > 0, nil
 	case ">=":
-		return x >= c.Value, nil
+		return n >= 0, nil
 	case "=":
-		return x == c.Value, nil
+		return n == 0, nil
 	}
-	return false, nil
 }
 
 func (c *Numeric) Matches(msg interface{}) (bool, error) {
-	for _, p := range c.Predicates {
-		if matches, err := p.Matches(msg); !matches || err != nil {
+	if c.Lower != nil {
+		if matches, err := c.Lower.Matches(msg); !matches || err != nil {
 			return matches, err
 		}
 	}
+	if c.Upper != nil {
+		return c.Upper.Matches(msg)
+	}
+	if c.Lower == nil {
+		return isNumber(msg), nil
+	}
 	return true, nil
 }
 
+// Empty reports whether no number is in the interval.
+func (c *Numeric) Empty() bool {
+	if c.Lower == nil || c.Upper == nil {
+		return false
+	}
+	n, ok := compareNumbers(c.Lower.Value, c.Upper.Value)
+	if !ok {
+		return false
+	}
+	return 0 < n || (n == 0 && (c.Lower.Relation == ">" || c.Upper.Relation == "<"))
+}
+
+// Point returns the interval's only number if the interval is a
+// single number.
+func (c *Numeric) Point() (interface{}, bool) {
+	if c.Lower == nil || c.Upper == nil ||
+		c.Lower.Relation != ">=" || c.Upper.Relation != "<=" {
+		return nil, false
+	}
+	if n, ok := compareNumbers(c.Lower.Value, c.Upper.Value); !ok || n != 0 {
+		return nil, false
+	}
+	return c.Lower.Value, true
+}
+
 type Exists struct {
 	Value bool
 }
 
+func (c *Exists) wholeValue() {}
+
+// Matches reports whether the value has a leaf (or doesn't when the
+// Value is false).
+//
+// As with EventBridge, "exists" only considers leaves: null and
+// other scalars are leaves, an object is not, and an array has a leaf
+// if any of its elements does.  So an empty array or an object
+// doesn't exist.
 func (c *Exists) Matches(msg interface{}) (bool, error) {
-	if msg == Missing {
-		return !c.Value, nil
+	return hasLeaf(msg) == c.Value, nil
+}
+
+func hasLeaf(x interface{}) bool {
+	switch vv := x.(type) {
+	case map[string]interface{}:
+		return false
+	case []interface{}:
+		for _, y := range vv {
+			if hasLeaf(y) {
+				return true
+			}
+		}
+		return false
 	}
-	return c.Value, nil
+	return x != Missing
 }
@@ -1,51 +1,83 @@
 package pat
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
 )
 
 type TestCase struct {
-	// AWS indicates that this test should work with AWS
-	// EventBridge TestEventPattern.
+	// AWS indicates that this test has been checked with AWS
+	// EventBridge TestEventPattern.  A case without it might
+	// still agree with EventBridge, but nobody has checked.
 	//
-	// See TestAWS() below.
+	// See TestWithAWS() below.
 	AWS bool `json:"aws,omitempty"`
 
+	// Arrays indicates that the pattern uses the array operators
+	// (see Cfg.Arrays).
+	Arrays bool `json:"arrays,omitempty"`
+
 	Pat     interface{} `json:"pat"`
 	Msg     interface{} `json:"msg"`
 	Matches bool        `json:"matches"`
 	Error   bool        `json:"error,omitempty"`
 }
 
-func TestBasic(t *testing.T) {
+// cfg returns the Cfg for parsing the case's pattern.
+func (tc TestCase) cfg() *Cfg {
+	return &Cfg{
+		Arrays: tc.Arrays,
+	}
+}
 
+// readCases reads the test cases in tests.json.
+// Numbers are decoded as json.Numbers to preserve their precision.
+func readCases(t testing.TB) []TestCase {
 	bs, err := ioutil.ReadFile("tests.json")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	var cases []TestCase
-	if err := json.Unmarshal(bs, &cases); err != nil {
+	var (
+		cases []TestCase
+		d     = json.NewDecoder(bytes.NewReader(bs))
+	)
+	d.UseNumber()
+	if err := d.Decode(&cases); err != nil {
 		t.Fatal(err)
 	}
 
-	cfg := &Cfg{}
+	return cases
+}
+
+func TestBasic(t *testing.T) {
+
+	cases := readCases(t)
 
 	for _, tc := range cases {
 		t.Run(JSON(tc), func(t *testing.T) {
-			c, err := cfg.ParsePattern(tc.Pat)
+			c, err := tc.cfg().ParsePattern(tc.Pat)
 			if err != nil {
+				if tc.Error {
+					return
+				}
 				t.Fatal(err)
 			}
+			if tc.Error {
+				t.Fatal("expected an error")
+			}
 			got, err := c.Matches(tc.Msg)
 			if err != nil {
 				t.Fatal(err)
@@ -59,30 +91,23 @@ func TestBasic(t *testing.T) {
 
 func BenchmarkBasic(b *testing.B) {
 
-	bs, err := ioutil.ReadFile("tests.json")
-	if err != nil {
-		b.Fatal(err)
-	}
-
-	var cases []TestCase
-	if err := json.Unmarshal(bs, &cases); err != nil {
-		b.Fatal(err)
-	}
-
-	cfg := &Cfg{}
+	cases := readCases(b)
 
 	var (
-		pats = make([]Constraint, len(cases))
-		msgs = make([]interface{}, len(cases))
+		pats = make([]Constraint, 0, len(cases))
+		msgs = make([]interface{}, 0, len(cases))
 	)
 
-	for i, tc := range cases {
-		c, err := cfg.ParsePattern(tc.Pat)
+	for _, tc := range cases {
+		if tc.Error {
+			continue
+		}
+		c, err := tc.cfg().ParsePattern(tc.Pat)
 		if err != nil {
 			b.Fatal(err)
 		}
-		pats[i] = c
-		msgs[i] = tc.Msg
+		pats = append(pats, c)
+		msgs = append(msgs, tc.Msg)
 	}
 
 	b.ResetTimer()
@@ -106,6 +131,9 @@ LOOP:
 func TestWithAWS(t *testing.T) {
 	if os.Getenv("AWS_PROFILE") == "" &&
 		os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+		if *record {
+			t.Fatal("-record requires AWS access")
+		}
 		t.Skip("AWS access not configured")
 	}
 
@@ -117,15 +145,7 @@ func TestWithAWS(t *testing.T) {
 
 	svc := eventbridge.NewFromConfig(cfg)
 
-	bs, err := ioutil.ReadFile("tests.json")
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	var cases []TestCase
-	if err := json.Unmarshal(bs, &cases); err != nil {
-		t.Fatal(err)
-	}
+	cases := readCases(t)
 
 	// The AWS TestEventPattern API insists on having certain
 	// top-level values.
@@ -147,17 +167,56 @@ func TestWithAWS(t *testing.T) {
 		return m
 	}
 
+	corpus := &Corpus{
+		Source:   "EventBridge TestEventPattern",
+		Recorded: time.Now().UTC().Format(time.RFC3339),
+		Region:   cfg.Region,
+	}
+
+	aborted := false
+
 	for _, tc := range cases {
-		if !tc.AWS {
+		// When recording, the corpus also gets verdicts for
+		// the cases that nobody has checked (except for the
+		// array operators, which EventBridge doesn't have).
+		if !tc.AWS && !(*record && !tc.Arrays && isObject(tc.Msg)) {
 			continue
 		}
+		if aborted {
+			break
+		}
 
 		t.Run(JSON(tc), func(t *testing.T) {
+			msg := ensure(tc.Msg)
 			in := &eventbridge.TestEventPatternInput{
-				Event:        aws.String(JSON(ensure(tc.Msg))),
+				Event:        aws.String(JSON(msg)),
 				EventPattern: aws.String(JSON(tc.Pat)),
 			}
 			out, err := svc.TestEventPattern(ctx, in)
+
+			v := Verdict{
+				Pat: tc.Pat,
+				Msg: msg,
+			}
+			if err != nil {
+				// Only a rejection of the pattern is a
+				// verdict.  Anything else (credentials,
+				// network, throttling) spoils the corpus.
+				var ipe *types.InvalidEventPatternException
+				if !errors.As(err, &ipe) {
+					aborted = true
+					t.Fatal(err)
+				}
+				v.Error, v.Message = true, err.Error()
+			} else {
+				v.Matches = out.Result
+			}
+			corpus.Cases = append(corpus.Cases, v)
+
+			if !tc.AWS {
+				// TestRecorded reports any disagreement.
+				return
+			}
 			if err != nil {
 				if tc.Error {
 					return
@@ -170,4 +229,96 @@ func TestWithAWS(t *testing.T) {
 			}
 		})
 	}
+
+	if aborted {
+		if *record {
+			t.Fatalf("not writing %s: EventBridge didn't give every verdict", corpusFile)
+		}
+		return
+	}
+	if *record {
+		writeCorpus(t, corpus)
+	}
+}
+
+// isObject reports whether the message is an object, which
+// TestEventPattern requires.
+func isObject(msg interface{}) bool {
+	_, is := msg.(map[string]interface{})
+	return is
+}
+
+func TestStrict(t *testing.T) {
+	cfg := &Cfg{
+		Strict: true,
+	}
+
+	for _, js := range []string{
+		`"tacos"`,
+		`["tacos"]`,
+		`{"want":"tacos"}`,
+		`{"want":{"many":"tacos"}}`,
+		`{"want":[["tacos"]]}`,
+		`{"want":[{"tacos":"queso"}]}`,
+		`{"$or":[{"want":"tacos"},{"want":["queso"]}]}`,
+		`{"flag":[{"anything-but":[true]}]}`,
+		`{"deleted":[{"anything-but":[null]}]}`,
+	} {
+		t.Run(js, func(t *testing.T) {
+			if _, err := cfg.ParsePattern(P(js)); err == nil {
+				t.Fatal("expected an error")
+			}
+			if _, err := (&Cfg{}).ParsePattern(P(js)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	// Every pattern that AWS accepts should parse in strict mode.
+
+	for _, tc := range readCases(t) {
+		if !tc.AWS || tc.Error {
+			continue
+		}
+		t.Run(JSON(tc.Pat), func(t *testing.T) {
+			if _, err := cfg.ParsePattern(tc.Pat); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestNumericErrors(t *testing.T) {
+	for js, want := range map[string]string{
+		`{"n":[{"numeric":["!=",3]}]}`:       "unknown numeric relation",
+		`{"n":[{"numeric":[">",1,">",2]}]}`:  "more than one numeric lower bound",
+		`{"n":[{"numeric":["<",1,"<=",2]}]}`: "more than one numeric upper bound",
+		`{"n":[{"numeric":[">",10,"<",5]}]}`: "empty numeric range",
+		`{"n":[{"numeric":[">=",5,"<",5]}]}`: "empty numeric range",
+		`{"n":[{"numeric":["<",6,"=",5]}]}`:  "can't be combined",
+		`{"n":[{"numeric":[">",1,"<"]}]}`:    "bad numeric array size",
+		`{"n":[{"numeric":[">","1"]}]}`:      "bad numeric relation value",
+		`{"n":[{"numeric":[1,">"]}]}`:        "bad numeric relation",
+	} {
+		t.Run(js, func(t *testing.T) {
+			_, err := ParsePattern(P(js))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), want) {
+				t.Fatalf("want %q in %q", want, err)
+			}
+		})
+	}
+}
+
+func TestNumericInterval(t *testing.T) {
+	c, err := ParsePattern(P(`{"n":[{"numeric":["<=",10,">",0]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := c.(Map)["n"].(Constraints)[0].(*Numeric)
+	if n.Lower == nil || n.Lower.Relation != ">" || n.Upper == nil || n.Upper.Relation != "<=" {
+		t.Fatal(JSON(n))
+	}
 }
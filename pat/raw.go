@@ -0,0 +1,199 @@
+package pat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// selection says which parts of a JSON document to decode.
+type selection interface {
+	// whole reports whether the entire value should be decoded.
+	whole() bool
+
+	// sub returns the selection for the given key of an object
+	// (or nil to skip that key's value).
+	sub(k string) selection
+}
+
+// paths is a selection of the paths that a pattern mentions.
+type paths struct {
+	all      bool
+	children map[string]*paths
+}
+
+func (p *paths) whole() bool {
+	return p.all
+}
+
+func (p *paths) sub(k string) selection {
+	if child, have := p.children[k]; have {
+		return child
+	}
+	return nil
+}
+
+// pathsOf returns a selection of the paths that the given pattern
+// mentions.
+func pathsOf(c interface{}) *paths {
+	p := newPaths()
+	p.add(c)
+	return p
+}
+
+// newPaths returns an empty selection.
+func newPaths() *paths {
+	return &paths{
+		children: make(map[string]*paths),
+	}
+}
+
+func (p *paths) add(c interface{}) {
+	switch vv := c.(type) {
+	case *pass:
+		// Pass doesn't look at anything.
+	case Map:
+		for k, v := range vv {
+			if o, is := v.(Or); is {
+				for _, d := range o {
+					p.add(d)
+				}
+				continue
+			}
+			child, have := p.children[k]
+			if !have {
+				child = &paths{
+					children: make(map[string]*paths),
+				}
+				p.children[k] = child
+			}
+			if m, is := v.(Map); is && 0 < len(m) {
+				child.add(m)
+			} else {
+				child.all = true
+			}
+		}
+	default:
+		p.all = true
+	}
+}
+
+// MatchesJSON matches the given pattern against the given JSON
+// document without decoding more of the document than necessary.
+//
+// The document is streamed, and only the values at the paths that the
+// pattern mentions are decoded.  Numbers are decoded as json.Numbers
+// to preserve their precision.  The result is the same as matching
+// against the fully decoded document.
+func MatchesJSON(c Constraint, js []byte) (bool, error) {
+	x, err := decodeSelection(js, pathsOf(c))
+	if err != nil {
+		return false, err
+	}
+	return c.Matches(x)
+}
+
+// decodeSelection decodes only the given selection of the given JSON
+// document.
+func decodeSelection(js []byte, s selection) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(js))
+	d.UseNumber()
+	x, err := decodeSelected(d, s)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, fmt.Errorf("trailing data after JSON document")
+	}
+	return x, nil
+}
+
+func decodeSelected(d *json.Decoder, s selection) (interface{}, error) {
+	if s.whole() {
+		var x interface{}
+		if err := d.Decode(&x); err != nil {
+			return nil, err
+		}
+		return x, nil
+	}
+
+	t, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	delim, is := t.(json.Delim)
+	switch {
+	case !is:
+		// A scalar where the selection wanted an object.  Since
+		// only a map can match here, the scalar itself will do.
+		return t, nil
+	case delim == '[':
+		// Similarly, only a map can match here, so we skip
+		// the array and return an empty one.
+		if err := skipRest(d); err != nil {
+			return nil, err
+		}
+		return []interface{}{}, nil
+	}
+
+	m := make(map[string]interface{})
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		k, is := t.(string)
+		if !is {
+			return nil, fmt.Errorf("bad key %#v", t)
+		}
+		if sub := s.sub(k); sub != nil {
+			x, err := decodeSelected(d, sub)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = x
+		} else if err := skip(d); err != nil {
+			return nil, err
+		}
+	}
+	// The closing '}'.
+	if _, err := d.Token(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// skip skips the next value.
+func skip(d *json.Decoder) error {
+	t, err := d.Token()
+	if err != nil {
+		return err
+	}
+	if delim, is := t.(json.Delim); is && (delim == '{' || delim == '[') {
+		return skipRest(d)
+	}
+	return nil
+}
+
+// skipRest skips the rest of an object or array whose opening
+// delimiter has been read.
+func skipRest(d *json.Decoder) error {
+	for depth := 1; 0 < depth; {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		if delim, is := t.(json.Delim); is {
+			switch delim {
+			case '{', '[':
+				depth++
+			default:
+				depth--
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package pat
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMatchesJSON(t *testing.T) {
+	for _, tc := range readCases(t) {
+		if tc.Error {
+			continue
+		}
+		t.Run(JSON(tc), func(t *testing.T) {
+			c, err := tc.cfg().ParsePattern(tc.Pat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := MatchesJSON(c, []byte(JSON(tc.Msg)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.Matches {
+				t.Fatal(got)
+			}
+		})
+	}
+}
+
+func TestDecodeSelection(t *testing.T) {
+	c, err := ParsePattern(P(`{"a":{"b":[1]},"c":[{"exists":true}],"$or":[{"d":[1]},{"e":{"f":[1]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	js := `{"a":{"b":1,"x":[1,{"y":2}]},"c":{"deep":[[]]},"z":{"a":{"b":1}},"e":[1,2],"d":"d","q":null}`
+	x, err := decodeSelection([]byte(js), pathsOf(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"a":{"b":1},"c":{"deep":[[]]},"d":"d","e":[]}`
+	if got := JSON(x); got != want+"\n" {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+
+	for _, js := range []string{
+		`{"a":`,
+		`{"a":{"b":1}} {}`,
+		`{"a":{"b":1]}`,
+	} {
+		if _, err := decodeSelection([]byte(js), pathsOf(c)); err == nil {
+			t.Fatal(js)
+		}
+	}
+}
+
+func BenchmarkMatchesJSON(b *testing.B) {
+	c, err := ParsePattern(P(`{"payload":{"want":["tacos"]}}`))
+	if err != nil {
+		b.Fatal(err)
+	}
+	msg := map[string]interface{}{
+		"payload": map[string]interface{}{
+			"want":  "tacos",
+			"other": P(`{"lots":[1,2,3,4,5,6,7,8,9],"of":{"stuff":["a","b","c"]}}`),
+		},
+	}
+	js, err := json.Marshal(msg)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if ok, err := MatchesJSON(c, js); !ok || err != nil {
+			b.Fatal(ok, err)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package pat
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MatchesValue matches the given pattern against an arbitrary Go
+// value (like a struct) without round-tripping that value through
+// JSON.
+//
+// The value is walked with reflection, and only the parts that the
+// pattern mentions are converted into their generic (JSON-decoded)
+// forms.  Struct fields follow the encoding/json rules: "json" tags,
+// "omitempty", "-", and embedded structs.  Values that implement
+// json.Marshaler or encoding.TextMarshaler are marshaled, and a
+// json.RawMessage is decoded as in MatchesJSON.  Integers keep their
+// precision.  The result is the same as matching against the
+// JSON-decoded form of the value (with json.Numbers).
+func MatchesValue(c Constraint, v interface{}) (bool, error) {
+	x, err := generic(reflect.ValueOf(v), pathsOf(c))
+	if err != nil {
+		return false, err
+	}
+	return c.Matches(x)
+}
+
+// MatchesValue is Matches for an arbitrary Go value.  See the
+// function MatchesValue.
+func (m *Machine) MatchesValue(v interface{}) ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	x, err := generic(reflect.ValueOf(v), m.selection())
+	if err != nil {
+		return nil, err
+	}
+	return m.matches(x), nil
+}
+
+// everything is a selection of an entire value.
+var everything = &paths{
+	all: true,
+}
+
+var (
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	rawMessageType    = reflect.TypeOf(json.RawMessage(nil))
+	numberType        = reflect.TypeOf(json.Number(""))
+)
+
+// generic returns the JSON-decoded form of the selected parts of the
+// given value.
+func generic(v reflect.Value, s selection) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	t := v.Type()
+
+	if t == rawMessageType {
+		if v.IsNil() {
+			return nil, nil
+		}
+		return decodeSelection(v.Bytes(), s)
+	}
+
+	if t == numberType {
+		// A json.Number is a string to reflect, but it's a
+		// number to encoding/json (which also checks it).
+		js, err := json.Marshal(v.Interface())
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(js), nil
+	}
+
+	if t.Implements(marshalerType) || t.Implements(textMarshalerType) ||
+		(v.CanAddr() && (reflect.PtrTo(t).Implements(marshalerType) ||
+			reflect.PtrTo(t).Implements(textMarshalerType))) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return nil, nil
+		}
+		if v.CanAddr() {
+			v = v.Addr()
+		}
+		js, err := json.Marshal(v.Interface())
+		if err != nil {
+			return nil, err
+		}
+		return decodeSelection(js, s)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return generic(v.Elem(), s)
+	case reflect.Struct:
+		return genericStruct(v, s)
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return genericMap(v, s)
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Base64 like encoding/json.
+			js, err := json.Marshal(v.Interface())
+			if err != nil {
+				return nil, err
+			}
+			var x interface{}
+			err = json.Unmarshal(js, &x)
+			return x, err
+		}
+		fallthrough
+	case reflect.Array:
+		if !s.whole() {
+			// Only an object could match here.
+			return []interface{}{}, nil
+		}
+		acc := make([]interface{}, v.Len())
+		for i := range acc {
+			x, err := generic(v.Index(i), everything)
+			if err != nil {
+				return nil, err
+			}
+			acc[i] = x
+		}
+		return acc, nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint(), nil
+	case reflect.Float32:
+		// encoding/json formats a float32 with 32-bit precision.
+		return strconv.ParseFloat(strconv.FormatFloat(v.Float(), 'g', -1, 32), 64)
+	case reflect.Float64:
+		return v.Float(), nil
+	}
+
+	// Channels, funcs, and complex numbers can't be marshaled.
+	_, err := json.Marshal(v.Interface())
+	return nil, err
+}
+
+func genericMap(v reflect.Value, s selection) (interface{}, error) {
+	m := make(map[string]interface{}, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		k, err := mapKey(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		sub := s
+		if !s.whole() {
+			if sub = s.sub(k); sub == nil {
+				continue
+			}
+		}
+		x, err := generic(iter.Value(), sub)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = x
+	}
+	return m, nil
+}
+
+// mapKey renders a map key like encoding/json does.
+func mapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if tm, is := k.Interface().(encoding.TextMarshaler); is {
+		if k.Kind() == reflect.Ptr && k.IsNil() {
+			return "", nil
+		}
+		bs, err := tm.MarshalText()
+		return string(bs), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	_, err := json.Marshal(map[interface{}]bool{k.Interface(): true})
+	return "", err
+}
+
+func genericStruct(v reflect.Value, s selection) (interface{}, error) {
+	fs := fieldsOf(v.Type())
+	m := make(map[string]interface{}, len(fs))
+	for _, f := range fs {
+		sub := s
+		if !s.whole() {
+			if sub = s.sub(f.name); sub == nil {
+				continue
+			}
+		}
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok {
+			continue
+		}
+		if f.omitEmpty && isEmpty(fv) {
+			continue
+		}
+		if f.quoted {
+			js, err := json.Marshal(fv.Interface())
+			if err != nil {
+				return nil, err
+			}
+			// Like encoding/json, ",string" also applies to a
+			// (non-nil) pointer to a scalar.
+			qv := fv
+			if qv.Kind() == reflect.Ptr && !qv.IsNil() {
+				qv = qv.Elem()
+			}
+			switch qv.Kind() {
+			case reflect.Bool, reflect.String,
+				reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+				reflect.Float32, reflect.Float64:
+				m[f.name] = string(js)
+				continue
+			}
+		}
+		x, err := generic(fv, sub)
+		if err != nil {
+			return nil, err
+		}
+		m[f.name] = x
+	}
+	return m, nil
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex except that it reports
+// a nil embedded pointer rather than panicking.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, j := range index {
+		if 0 < i && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(j)
+	}
+	return v, true
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// field is a struct field as encoding/json sees it.
+type field struct {
+	name      string
+	index     []int
+	tagged    bool
+	omitEmpty bool
+	quoted    bool
+}
+
+// fieldCache maps a reflect.Type to its []field.
+var fieldCache sync.Map
+
+func fieldsOf(t reflect.Type) []field {
+	if fs, have := fieldCache.Load(t); have {
+		return fs.([]field)
+	}
+	fs, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return fs.([]field)
+}
+
+// typeFields finds the fields that encoding/json would encode for
+// the given struct type, including the fields of embedded structs,
+// following (a simplification of) encoding/json's rules.
+func typeFields(t reflect.Type) []field {
+	type embedded struct {
+		t     reflect.Type
+		index []int
+	}
+
+	var (
+		current = []embedded{}
+		next    = []embedded{{t, nil}}
+		visited = make(map[reflect.Type]bool)
+		byName  = make(map[string][]field)
+		names   []string
+	)
+
+	for 0 < len(next) {
+		current, next = next, nil
+		for _, e := range current {
+			if visited[e.t] {
+				continue
+			}
+			visited[e.t] = true
+			for i := 0; i < e.t.NumField(); i++ {
+				sf := e.t.Field(i)
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous {
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts := tag, ""
+				if i := strings.Index(tag, ","); 0 <= i {
+					name, opts = tag[:i], tag[i:]
+				}
+
+				index := make([]int, len(e.index)+1)
+				copy(index, e.index)
+				index[len(e.index)] = i
+
+				if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, embedded{ft, index})
+					continue
+				}
+
+				f := field{
+					name:      name,
+					index:     index,
+					tagged:    name != "",
+					omitEmpty: strings.Contains(opts, ",omitempty"),
+					quoted:    strings.Contains(opts, ",string"),
+				}
+				if f.name == "" {
+					f.name = sf.Name
+				}
+				if _, have := byName[f.name]; !have {
+					names = append(names, f.name)
+				}
+				byName[f.name] = append(byName[f.name], f)
+			}
+		}
+	}
+
+	// The shallowest field wins, and a tagged field beats an
+	// untagged field at the same depth.  Otherwise, ambiguous
+	// fields are dropped.
+	acc := make([]field, 0, len(names))
+	for _, name := range names {
+		fs := byName[name]
+		depth := len(fs[0].index)
+		var (
+			winner *field
+			n      int
+		)
+		for i := range fs {
+			f := &fs[i]
+			if len(f.index) != depth {
+				continue
+			}
+			switch {
+			case winner == nil:
+				winner, n = f, 1
+			case f.tagged && !winner.tagged:
+				winner, n = f, 1
+			case f.tagged == winner.tagged:
+				n++
+			}
+		}
+		if n == 1 {
+			acc = append(acc, *winner)
+		}
+	}
+
+	return acc
+}
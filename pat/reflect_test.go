@@ -0,0 +1,306 @@
+package pat
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Base struct {
+	Id      string `json:"id"`
+	Shadow  string `json:"shadow"`
+	private string
+}
+
+type Extra struct {
+	Note string `json:"note,omitempty"`
+}
+
+type Order struct {
+	Base
+	*Extra
+
+	Shadow   int               `json:"shadow"`
+	Total    float32           `json:"total"`
+	Count    int64             `json:"count,string"`
+	QP       *int              `json:"qp,string"`
+	QS       *string           `json:"qs,omitempty,string"`
+	Tags     []string          `json:"tags,omitempty"`
+	When     time.Time         `json:"when"`
+	Raw      json.RawMessage   `json:"raw,omitempty"`
+	Bytes    []byte            `json:"bytes,omitempty"`
+	ByNumber map[int]string    `json:"byNumber,omitempty"`
+	Any      interface{}       `json:"any"`
+	Number   json.Number       `json:"number,omitempty"`
+	Nested   *Order            `json:"nested,omitempty"`
+	Ignored  string            `json:"-"`
+	Untagged map[string]string ``
+}
+
+func orders() []interface{} {
+	when := time.Date(2022, 2, 4, 1, 2, 3, 0, time.UTC)
+	qp, qs := 7, "q"
+	return []interface{}{
+		Order{},
+		&Order{
+			Base: Base{
+				Id:      "o1",
+				Shadow:  "hidden",
+				private: "secret",
+			},
+			Extra: &Extra{
+				Note: "rush",
+			},
+			Shadow:   3,
+			Total:    0.1,
+			Count:    42,
+			QP:       &qp,
+			QS:       &qs,
+			Tags:     []string{"a", "b"},
+			When:     when,
+			Raw:      json.RawMessage(`{"x":[1,{"y":"z"}]}`),
+			Bytes:    []byte("tacos"),
+			ByNumber: map[int]string{1: "one"},
+			Any:      map[string]interface{}{"deep": []interface{}{1, "two"}},
+			Number:   json.Number("12345678901234567890"),
+			Nested: &Order{
+				Base: Base{
+					Id: "o2",
+				},
+			},
+			Ignored: "ignored",
+			Untagged: map[string]string{
+				"k": "v",
+			},
+		},
+		map[string]interface{}{
+			"order": Order{
+				Tags: []string{"c"},
+			},
+		},
+		map[string]interface{}{
+			"number": json.Number("5"),
+		},
+		[]Order{{}},
+		"tacos",
+		uint8(7),
+	}
+}
+
+// TestGeneric checks that the reflection-based conversion agrees with
+// a JSON round trip.
+func TestGeneric(t *testing.T) {
+	for _, v := range orders() {
+		js, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(string(js), func(t *testing.T) {
+			want, err := decodeSelection(js, everything)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := generic(reflect.ValueOf(v), everything)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !same(want, got) {
+				t.Fatalf("\nwant %s\ngot  %s", JSON(want), JSON(got))
+			}
+		})
+	}
+}
+
+// same is reflect.DeepEqual except that numbers are compared by
+// value.
+func same(x, y interface{}) bool {
+	if n, ok := compareNumbers(x, y); ok {
+		return n == 0
+	}
+	switch vv := x.(type) {
+	case map[string]interface{}:
+		m, is := y.(map[string]interface{})
+		if !is || len(m) != len(vv) {
+			return false
+		}
+		for k, v := range vv {
+			if w, have := m[k]; !have || !same(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		ys, is := y.([]interface{})
+		if !is || len(ys) != len(vv) {
+			return false
+		}
+		for i := range vv {
+			if !same(vv[i], ys[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func TestMatchesValue(t *testing.T) {
+	pats := []string{
+		`{"id":["o1"]}`,
+		`{"shadow":[3]}`,
+		`{"shadow":["hidden"]}`,
+		`{"note":["rush"]}`,
+		`{"note":[{"exists":false}]}`,
+		`{"total":[0.1]}`,
+		`{"count":["42"]}`,
+		`{"qp":["7"]}`,
+		`{"qp":[7]}`,
+		`{"qp":[null]}`,
+		`{"qs":["\"q\""]}`,
+		`{"tags":["b"]}`,
+		`{"tags":[{"exists":false}]}`,
+		`{"when":[{"prefix":"2022-02-04"}]}`,
+		`{"raw":{"x":[1]}}`,
+		`{"bytes":["dGFjb3M="]}`,
+		`{"byNumber":{"1":["one"]}}`,
+		`{"any":{"deep":["two"]}}`,
+		`{"number":[12345678901234567890]}`,
+		`{"number":[{"numeric":[">",1]}]}`,
+		`{"number":[{"numeric":[">",12345678901234567889]}]}`,
+		`{"nested":{"id":["o2"]}}`,
+		`{"Ignored":["ignored"]}`,
+		`{"Untagged":{"k":["v"]}}`,
+		`{"private":["secret"]}`,
+		`{"order":{"tags":["c"]}}`,
+	}
+
+	m := NewMachine()
+	for _, p := range pats {
+		c, err := ParsePattern(P(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Add(p, c)
+	}
+
+	for _, v := range orders() {
+		js, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range pats {
+			t.Run(p+" "+string(js), func(t *testing.T) {
+				c, err := ParsePattern(P(p))
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, err := MatchesJSON(c, js)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := MatchesValue(c, v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Fatal(got)
+				}
+			})
+		}
+
+		want, err := m.MatchesJSON(js)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := m.MatchesValue(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("%s: want %v, got %v", js, want, got)
+		}
+	}
+}
+
+func BenchmarkMatchesValue(b *testing.B) {
+	c, err := ParsePattern(P(`{"nested":{"id":["o2"]}}`))
+	if err != nil {
+		b.Fatal(err)
+	}
+	v := orders()[1]
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if ok, err := MatchesValue(c, v); !ok || err != nil {
+			b.Fatal(ok, err)
+		}
+	}
+}
+
+// expensive is a value that records whether it was marshaled.
+type expensive struct {
+	marshaled *bool
+}
+
+func (e expensive) MarshalJSON() ([]byte, error) {
+	*e.marshaled = true
+	return []byte(`"big"`), nil
+}
+
+// TestMachinePassSelective checks that a Pass pattern in a Machine
+// doesn't force the conversion of the whole message.
+func TestMachinePassSelective(t *testing.T) {
+	m := NewMachine()
+	c, err := ParsePattern(P(`{"a":["x"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Add("a", c)
+	m.Add("pass", Pass)
+
+	var marshaled bool
+	msg := struct {
+		A string    `json:"a"`
+		B expensive `json:"b"`
+	}{"x", expensive{&marshaled}}
+
+	ids, err := m.MatchesValue(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"a", "pass"}) {
+		t.Fatal(ids)
+	}
+	if marshaled {
+		t.Fatal("converted the whole message")
+	}
+
+	ids, err = m.MatchesJSON([]byte(`{"a":"y","b":"big"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"pass"}) {
+		t.Fatal(ids)
+	}
+
+	// A pattern that isn't indexed still gets the paths it
+	// mentions.
+	m.Add("b", Or{Map{"b": Constraints{&Literal{Value: "big"}}}})
+	if ids, err = m.MatchesValue(msg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"a", "b", "pass"}) || !marshaled {
+		t.Fatal(ids, marshaled)
+	}
+
+	m.Remove("b")
+	marshaled = false
+	if _, err = m.MatchesValue(msg); err != nil {
+		t.Fatal(err)
+	}
+	if marshaled {
+		t.Fatal("converted the whole message after Remove")
+	}
+}
@@ -0,0 +1,819 @@
+package pat
+
+import (
+	"encoding/json"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Answer is the result of a question that might not be decidable.
+type Answer int
+
+const (
+	Unknown Answer = iota
+	Yes
+	No
+)
+
+func (a Answer) String() string {
+	switch a {
+	case Yes:
+		return "yes"
+	case No:
+		return "no"
+	default:
+		return "unknown"
+	}
+}
+
+// Subsumes reports whether every message that matches b also
+// matches a, so that a rule with pattern b is redundant given a rule
+// with pattern a.
+//
+// A Yes is based on symbolic reasoning about Maps (with "$or"s),
+// literals, Prefix, Suffix, Numeric, Exists, and AnythingBut (but not
+// Element or Length).  A No
+// comes with a counterexample: a message that this function
+// constructed and checked to match b but not a.  Otherwise the answer
+// is Unknown.
+//
+// Messages are allowed to have arrays, so (for example)
+// {"n":[{"numeric":["<",0]}]} doesn't subsume {"n":[-1]} when the
+// latter is a literal leaf rather than an array of constraints.
+func Subsumes(a, b Constraint) Answer {
+	if same, ok := sameJSON(a, b); ok && same {
+		return Yes
+	}
+	ma, is := a.(Map)
+	if !is {
+		return Unknown
+	}
+	mb, is := b.(Map)
+	if !is {
+		return Unknown
+	}
+	as, bs := conjunctions(ma), conjunctions(mb)
+
+	proved := true
+	for _, cb := range bs {
+		covered := false
+		for _, ca := range as {
+			if ca.subsumes(cb) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			proved = false
+			break
+		}
+	}
+	if proved {
+		return Yes
+	}
+
+	for _, cb := range bs {
+		if searchEvents(cb, as, func(msg interface{}) bool {
+			return matches(b, msg) && !matches(a, msg)
+		}) {
+			return No
+		}
+	}
+
+	return Unknown
+}
+
+// Intersects reports whether some message matches both a and b.
+//
+// A Yes comes with an example: a message that this function
+// constructed and checked to match both patterns.  A No is based on
+// symbolic reasoning, which is limited since messages can have
+// arrays.  For example, {"n":[1]} and {"n":[2]} intersect because
+// both match {"n":[1,2]}.  Otherwise the answer is Unknown.
+func Intersects(a, b Constraint) Answer {
+	ma, is := a.(Map)
+	if !is {
+		return Unknown
+	}
+	mb, is := b.(Map)
+	if !is {
+		return Unknown
+	}
+	as, bs := conjunctions(ma), conjunctions(mb)
+
+	for _, ca := range as {
+		for _, cb := range bs {
+			both := conjunction{
+				leaves: append(append([]leaf{}, ca.leaves...), cb.leaves...),
+			}
+			if searchEvents(both, nil, func(msg interface{}) bool {
+				return matches(a, msg) && matches(b, msg)
+			}) {
+				return Yes
+			}
+		}
+	}
+
+	for _, ca := range as {
+		for _, cb := range bs {
+			if !ca.disjoint(cb) {
+				return Unknown
+			}
+		}
+	}
+	return No
+}
+
+// matches is Matches without the error.
+func matches(c Constraint, msg interface{}) bool {
+	ok, err := c.Matches(msg)
+	return ok && err == nil
+}
+
+// sameJSON reports whether the canonical JSON for the given values
+// is the same.
+func sameJSON(x, y interface{}) (bool, bool) {
+	jx, err := marshal(x)
+	if err != nil {
+		return false, false
+	}
+	jy, err := marshal(y)
+	if err != nil {
+		return false, false
+	}
+	return string(jx) == string(jy), true
+}
+
+// conjunction is one of the alternatives of a pattern.
+type conjunction struct {
+	leaves []leaf
+}
+
+func conjunctions(m Map) []conjunction {
+	alts := alternatives(m, nil)
+	acc := make([]conjunction, len(alts))
+	for i, ls := range alts {
+		acc[i] = conjunction{
+			leaves: ls,
+		}
+	}
+	return acc
+}
+
+func pathKey(path []string) string {
+	return strings.Join(path, "\x00")
+}
+
+// isPrefixPath reports whether p is a proper prefix of q.
+func isPrefixPath(p, q []string) bool {
+	if len(q) <= len(p) {
+		return false
+	}
+	for i := range p {
+		if p[i] != q[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// subsumes reports whether the conjunction c provably subsumes d.
+func (c conjunction) subsumes(d conjunction) bool {
+	for _, l := range c.leaves {
+		covered := false
+		for _, m := range d.leaves {
+			if pathKey(l.path) == pathKey(m.path) && fieldSubsumes(l.pat, m.pat) {
+				covered = true
+				break
+			}
+			if isPrefixPath(l.path, m.path) && isEmptyMap(l.pat) && !matchesMissing(m.pat) {
+				// d requires a value at the longer path
+				// and so an object here.  (A nested
+				// pattern that matches a missing value is
+				// also satisfied without the object.)
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// disjoint reports whether the conjunctions provably have no
+// message in common.
+func (c conjunction) disjoint(d conjunction) bool {
+	for _, l := range c.leaves {
+		for _, m := range d.leaves {
+			if pathKey(l.path) == pathKey(m.path) && fieldDisjoint(l.pat, m.pat) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesMissing reports whether the pattern at a key matches a
+// missing value at that key.
+func matchesMissing(p interface{}) bool {
+	return fieldMatchesAll([]interface{}{p}, Missing)
+}
+
+func isEmptyMap(x interface{}) bool {
+	m, is := x.(Map)
+	return is && len(m) == 0
+}
+
+// isScalar reports whether the value is a string, number, bool, or
+// null.
+func isScalar(x interface{}) bool {
+	switch x.(type) {
+	case string, bool, nil, json.Number:
+		return true
+	}
+	return isNumber(x)
+}
+
+// fieldSubsumes reports whether the pattern x at some key provably
+// matches every value (including a missing value) at that key that
+// the pattern y matches.
+func fieldSubsumes(x, y interface{}) bool {
+	if same, ok := sameJSON(x, y); ok && same {
+		return true
+	}
+	switch xx := x.(type) {
+	case Constraints:
+		switch yy := y.(type) {
+		case Constraints:
+			if matches(yy, Missing) && !matches(xx, Missing) {
+				return false
+			}
+			for _, c := range yy {
+				covered := false
+				for _, d := range xx {
+					if leafSubsumes(d, c) {
+						covered = true
+						break
+					}
+				}
+				if !covered {
+					return false
+				}
+			}
+			return true
+		case *Literal:
+			return isScalar(yy.Value) && matches(xx, yy.Value)
+		}
+	case *Literal:
+		if yy, is := y.(*Literal); is {
+			return isScalar(xx.Value) && isScalar(yy.Value) && matches(xx, yy.Value)
+		}
+	}
+	return false
+}
+
+// leafSubsumes reports whether the element x of an array of
+// constraints provably matches every value that the element y
+// matches.
+//
+// An {"exists":false} also matches objects and arrays without
+// leaves, so only another {"exists":false} subsumes it.
+func leafSubsumes(x, y Constraint) bool {
+	if same, ok := sameJSON(x, y); ok && same {
+		return true
+	}
+	if isArrayOperator(x) || isArrayOperator(y) {
+		// No rules (yet) for these.
+		return false
+	}
+	if e, is := x.(*Exists); is && e.Value {
+		return onlyLeaves(y)
+	}
+	if _, is := y.(wholeValue); is {
+		return false
+	}
+	if l, is := y.(*Literal); is {
+		return isScalar(l.Value) && matches(x, l.Value)
+	}
+
+	switch xx := x.(type) {
+	case *Prefix:
+		if yy, is := y.(*Prefix); is {
+			return strings.HasPrefix(yy.Value, xx.Value)
+		}
+	case *Suffix:
+		if yy, is := y.(*Suffix); is {
+			return strings.HasSuffix(yy.Value, xx.Value)
+		}
+	case *Numeric:
+		if yy, is := y.(*Numeric); is {
+			return xx.contains(yy)
+		}
+	case *AnythingBut:
+		if xx.Constraint == nil {
+			// y must only match leaves (since x doesn't match
+			// anything else), and y must not match any of the
+			// excluded values.
+			if !onlyLeaves(y) {
+				return false
+			}
+			for _, v := range xx.Value {
+				if ok, err := y.Matches(v); ok || err != nil {
+					return false
+				}
+			}
+			return true
+		}
+		if !stringsOnly(y) {
+			return false
+		}
+		if yy, is := y.(*AnythingBut); is {
+			// y excludes more than x does.
+			return stringsSubset(xx.Constraint, yy.Constraint)
+		}
+		return stringsDisjoint(xx.Constraint, y)
+	}
+	return false
+}
+
+// isArrayOperator reports whether the constraint is an Element or a
+// Length.
+func isArrayOperator(c Constraint) bool {
+	switch c.(type) {
+	case *Element, *Length:
+		return true
+	}
+	return false
+}
+
+// hasArrayOperator reports whether the pattern at a key is a
+// Constraints with an Element or a Length.
+func hasArrayOperator(p interface{}) bool {
+	cs, is := p.(Constraints)
+	if !is {
+		return false
+	}
+	for _, c := range cs {
+		if isArrayOperator(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyLeaves reports whether the element of an array of constraints
+// only matches leaves, so that a value with an element that it
+// matches exists.
+func onlyLeaves(c Constraint) bool {
+	switch cc := c.(type) {
+	case *Numeric:
+		return true
+	case *Literal:
+		return isScalar(cc.Value)
+	case *AnythingBut:
+		return true
+	}
+	return stringsOnly(c)
+}
+
+// stringsOnly reports whether the constraint only matches strings.
+func stringsOnly(c Constraint) bool {
+	switch cc := c.(type) {
+	case *Prefix, *Suffix, *EqualsIgnoreCase, *Wildcard, *CIDR:
+		return true
+	case *AnythingBut:
+		return cc.Constraint != nil
+	}
+	return false
+}
+
+// stringsSubset reports whether the string constraint x provably
+// only matches strings that y matches.
+func stringsSubset(x, y Constraint) bool {
+	switch yy := y.(type) {
+	case *Prefix:
+		if xx, is := x.(*Prefix); is {
+			return strings.HasPrefix(xx.Value, yy.Value)
+		}
+	case *Suffix:
+		if xx, is := x.(*Suffix); is {
+			return strings.HasSuffix(xx.Value, yy.Value)
+		}
+	}
+	return false
+}
+
+// stringsDisjoint reports whether the string constraints provably
+// have no string in common.
+func stringsDisjoint(x, y Constraint) bool {
+	switch xx := x.(type) {
+	case *Prefix:
+		if yy, is := y.(*Prefix); is {
+			return !strings.HasPrefix(xx.Value, yy.Value) && !strings.HasPrefix(yy.Value, xx.Value)
+		}
+	case *Suffix:
+		if yy, is := y.(*Suffix); is {
+			return !strings.HasSuffix(xx.Value, yy.Value) && !strings.HasSuffix(yy.Value, xx.Value)
+		}
+	}
+	return false
+}
+
+// contains reports whether the interval c contains the interval d.
+func (c *Numeric) contains(d *Numeric) bool {
+	return below(c.Lower, d.Lower, ">") && below(d.Upper, c.Upper, "<")
+}
+
+// below reports whether the bound p is at or below the bound q, where
+// both are lower bounds (with the given strict relation ">") or both
+// are upper bounds (with "<").  A nil lower bound is at minus
+// infinity, and a nil upper bound is at plus infinity.
+func below(p, q *NumericPredicate, strict string) bool {
+	if strict == ">" {
+		if p == nil {
+			return true
+		}
+		if q == nil {
+			return false
+		}
+	} else {
+		if q == nil {
+			return true
+		}
+		if p == nil {
+			return false
+		}
+	}
+	n, ok := compareNumbers(p.Value, q.Value)
+	if !ok {
+		return false
+	}
+	if n != 0 {
+		return n < 0
+	}
+	// Same value: an exclusive bound is tighter.
+	if strict == ">" {
+		return p.Relation != strict || q.Relation == strict
+	}
+	return q.Relation != strict || p.Relation == strict
+}
+
+// fieldDisjoint reports whether no value (including a missing
+// value) at some key provably matches both patterns x and y.
+func fieldDisjoint(x, y interface{}) bool {
+	if hasArrayOperator(x) || hasArrayOperator(y) {
+		return false
+	}
+	switch xx := x.(type) {
+	case Constraints:
+		switch yy := y.(type) {
+		case Constraints:
+			if matches(xx, Missing) && matches(yy, Missing) {
+				return false
+			}
+			// Otherwise an array could have an element that
+			// matches x and another that matches y.
+			return (!matchesLeaves(xx) && onlyExists(yy)) ||
+				(!matchesLeaves(yy) && onlyExists(xx))
+		case *Literal:
+			return isScalar(yy.Value) && !matches(xx, yy.Value)
+		}
+	case *Literal:
+		switch yy := y.(type) {
+		case Constraints:
+			return fieldDisjoint(yy, xx)
+		case *Literal:
+			return isScalar(xx.Value) && isScalar(yy.Value) && !matches(xx, yy.Value)
+		}
+	}
+	return false
+}
+
+// matchesLeaves reports whether the constraints might match a value
+// that exists (has a leaf).
+func matchesLeaves(cs Constraints) bool {
+	if len(cs) == 0 {
+		return true
+	}
+	for _, c := range cs {
+		if e, is := c.(*Exists); is && !e.Value {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// onlyExists reports whether the constraints only match values that
+// exist (have leaves).  Objects and arrays without leaves don't
+// exist.
+func onlyExists(cs Constraints) bool {
+	for _, c := range cs {
+		if e, is := c.(*Exists); is && e.Value {
+			continue
+		}
+		if !onlyLeaves(c) {
+			return false
+		}
+	}
+	return 0 < len(cs)
+}
+
+// groups returns the conjunction's paths (sorted) and the patterns
+// at each path (by pathKey).
+func (c conjunction) groups() ([][]string, map[string][]interface{}) {
+	var (
+		paths  [][]string
+		groups = make(map[string][]interface{})
+	)
+	for _, l := range c.leaves {
+		k := pathKey(l.path)
+		if _, have := groups[k]; !have {
+			paths = append(paths, l.path)
+		}
+		groups[k] = append(groups[k], l.pat)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return pathKey(paths[i]) < pathKey(paths[j])
+	})
+	for _, ps := range groups {
+		sort.SliceStable(ps, func(i, j int) bool {
+			return JSON(ps[i]) < JSON(ps[j])
+		})
+	}
+	return paths, groups
+}
+
+// maxSamples limits the number of values that searchEvents tries at
+// each path.
+const maxSamples = 64
+
+// searchEvents constructs messages that satisfy the conjunction c
+// (if it can), varying the values at c's paths and at the paths of
+// the other conjunctions, and reports whether any of those messages
+// passes the test.
+func searchEvents(c conjunction, others []conjunction, test func(msg interface{}) bool) bool {
+	paths, groups := c.groups()
+
+	// For each path, the values that satisfy all of c's patterns
+	// at that path.
+	options := make([][]interface{}, len(paths))
+	for i, p := range paths {
+		k := pathKey(p)
+		var hints []interface{}
+		for _, o := range others {
+			for _, l := range o.leaves {
+				if pathKey(l.path) == k {
+					hints = append(hints, l.pat)
+				}
+			}
+		}
+		for _, v := range fieldSamples(groups[k], hints) {
+			if fieldMatchesAll(groups[k], v) {
+				options[i] = append(options[i], v)
+			}
+		}
+		if len(options[i]) == 0 {
+			return false
+		}
+	}
+
+	build := func(i int, v interface{}) map[string]interface{} {
+		msg := make(map[string]interface{})
+		for j, p := range paths {
+			x := options[j][0]
+			if j == i {
+				x = v
+			}
+			place(msg, p, x)
+		}
+		return msg
+	}
+
+	if test(build(-1, nil)) {
+		return true
+	}
+	for i := range paths {
+		for _, v := range options[i][1:] {
+			if test(build(i, v)) {
+				return true
+			}
+		}
+	}
+
+	// Now try values at other paths.
+	for _, o := range others {
+		for _, l := range o.leaves {
+			if _, have := groups[pathKey(l.path)]; have {
+				continue
+			}
+			for _, v := range fieldSamples([]interface{}{l.pat}, nil) {
+				msg := build(-1, nil)
+				place(msg, l.path, v)
+				if test(msg) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// fieldMatchesAll reports whether the value (which might be Missing)
+// at a key matches all of the given patterns for that key.
+func fieldMatchesAll(ps []interface{}, v interface{}) bool {
+	m := make(map[string]interface{})
+	if v != Missing {
+		m["k"] = v
+	}
+	for _, p := range ps {
+		if !matches(Map{"k": p}, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// place sets the value at the given path, making objects as needed.
+// A Missing value just makes the parent objects.
+func place(msg map[string]interface{}, path []string, v interface{}) {
+	for _, k := range path[:len(path)-1] {
+		m, is := msg[k].(map[string]interface{})
+		if !is {
+			m = make(map[string]interface{})
+			msg[k] = m
+		}
+		msg = m
+	}
+	k := path[len(path)-1]
+	if v == Missing {
+		delete(msg, k)
+		return
+	}
+	msg[k] = v
+}
+
+// fieldSamples returns candidate values for a key from the given
+// patterns and hints (which are other patterns at that key),
+// including Missing and arrays.
+func fieldSamples(ps []interface{}, hints []interface{}) []interface{} {
+	var scalars []interface{}
+	for _, p := range append(append([]interface{}{}, ps...), hints...) {
+		scalars = append(scalars, samples(p)...)
+	}
+	scalars = append(scalars, "", "x", float64(0), float64(1), float64(-1), true, false, nil,
+		map[string]interface{}{})
+	if maxSamples < len(scalars) {
+		scalars = scalars[:maxSamples]
+	}
+
+	acc := make([]interface{}, 0, 2+2*len(scalars)+len(scalars)*len(scalars)/2)
+	acc = append(acc, Missing, []interface{}{})
+	acc = append(acc, scalars...)
+	for _, x := range scalars {
+		acc = append(acc, []interface{}{x})
+	}
+	for i, x := range scalars {
+		for _, y := range scalars[i+1:] {
+			acc = append(acc, []interface{}{x, y})
+		}
+	}
+	return acc
+}
+
+// samples returns some values that the given pattern matches (or
+// almost matches).
+func samples(p interface{}) []interface{} {
+	switch c := p.(type) {
+	case Constraints:
+		var acc []interface{}
+		for _, d := range c {
+			acc = append(acc, samples(d)...)
+		}
+		return acc
+	case Map:
+		m := make(map[string]interface{}, len(c))
+		for k, v := range c {
+			if ss := samples(v); 0 < len(ss) {
+				m[k] = ss[0]
+			}
+		}
+		return []interface{}{m}
+	case *Literal:
+		return []interface{}{c.Value}
+	case *Prefix:
+		return []interface{}{c.Value, c.Value + "x"}
+	case *Suffix:
+		return []interface{}{c.Value, "x" + c.Value}
+	case *EqualsIgnoreCase:
+		return []interface{}{c.Value, strings.ToUpper(c.Value), strings.ToLower(c.Value)}
+	case *Wildcard:
+		return []interface{}{strings.Join(c.segments, ""), strings.Join(c.segments, "x")}
+	case *CIDR:
+		return []interface{}{c.prefix.Addr().String()}
+	case *Numeric:
+		var acc []interface{}
+		for _, b := range []*NumericPredicate{c.Lower, c.Upper} {
+			if b == nil {
+				continue
+			}
+			acc = append(acc, b.Value)
+			for _, d := range []string{"-1", "1", "-1/2", "1/2"} {
+				if x, ok := offset(b.Value, d); ok {
+					acc = append(acc, x)
+				}
+			}
+		}
+		if c.Lower != nil && c.Upper != nil {
+			l, _ := toNumber(c.Lower.Value)
+			u, _ := toNumber(c.Upper.Value)
+			mid := new(big.Rat).Add(l.rat(), u.rat())
+			acc = append(acc, ratValue(mid.Quo(mid, big.NewRat(2, 1))))
+		}
+		return acc
+	case *Length:
+		var acc []interface{}
+		for _, x := range samples(c.Numeric) {
+			n, ok := toNumber(x)
+			if !ok || !n.rat().IsInt() {
+				continue
+			}
+			if k := n.rat().Num().Int64(); 0 <= k && k <= 16 {
+				xs := make([]interface{}, k)
+				for i := range xs {
+					xs[i] = "x"
+				}
+				acc = append(acc, xs)
+			}
+		}
+		return acc
+	case *Element:
+		i := c.Index
+		if i < 0 {
+			i = -i - 1
+		}
+		if 16 < i {
+			return nil
+		}
+		var acc []interface{}
+		for _, x := range samples(c.Pattern) {
+			xs := make([]interface{}, i+1)
+			for j := range xs {
+				xs[j] = ""
+			}
+			if c.Index < 0 {
+				xs[0] = x
+			} else {
+				xs[i] = x
+			}
+			acc = append(acc, xs)
+		}
+		return acc
+	case *AnythingBut:
+		if c.Constraint != nil {
+			return samples(c.Constraint)
+		}
+		var acc []interface{}
+		for _, v := range c.Value {
+			acc = append(acc, v)
+			switch vv := v.(type) {
+			case string:
+				acc = append(acc, vv+"x")
+			default:
+				if x, ok := offset(v, "1"); ok {
+					acc = append(acc, x)
+				}
+			}
+		}
+		return acc
+	}
+	if isScalar(p) {
+		// A literal in a nested pattern.
+		return []interface{}{p}
+	}
+	return nil
+}
+
+// offset adds the given rational (like "-1/2") to a number exactly.
+func offset(x interface{}, d string) (interface{}, bool) {
+	n, ok := toNumber(x)
+	if !ok {
+		return nil, false
+	}
+	r, _ := new(big.Rat).SetString(d)
+	return ratValue(r.Add(r, n.rat())), true
+}
+
+// ratValue renders a rational as a json.Number if it's an integer and
+// as a float64 otherwise.
+func ratValue(r *big.Rat) interface{} {
+	if r.IsInt() {
+		return json.Number(r.Num().String())
+	}
+	f, _ := r.Float64()
+	return f
+}
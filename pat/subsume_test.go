@@ -0,0 +1,211 @@
+package pat
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSubsumes(t *testing.T) {
+	cfg := &Cfg{
+		Arrays: true,
+	}
+
+	try := func(a, b string, want Answer) {
+		t.Run(a+" "+b, func(t *testing.T) {
+			pa, err := cfg.ParsePattern(P(a))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pb, err := cfg.ParsePattern(P(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Subsumes(pa, pb); got != want {
+				t.Fatalf("want %s, got %s", want, got)
+			}
+		})
+	}
+
+	try(`{"a":["x"]}`, `{"a":["x"]}`, Yes)
+	try(`{"a":["x","y"]}`, `{"a":["x"]}`, Yes)
+	try(`{"a":["x"]}`, `{"a":["x","y"]}`, No)
+	try(`{"a":["x"]}`, `{"a":["x"],"b":[1]}`, Yes)
+	try(`{"a":["x"],"b":[1]}`, `{"a":["x"]}`, No)
+	try(`{"a":[{"prefix":"ab"}]}`, `{"a":[{"prefix":"abc"}]}`, Yes)
+	try(`{"a":[{"prefix":"abc"}]}`, `{"a":[{"prefix":"ab"}]}`, No)
+	try(`{"a":[{"prefix":"ab"}]}`, `{"a":["abx"]}`, Yes)
+	try(`{"a":[{"suffix":".txt"}]}`, `{"a":[{"suffix":"a.txt"}]}`, Yes)
+	try(`{"n":[{"numeric":[">",0]}]}`, `{"n":[{"numeric":[">=",1,"<",5]}]}`, Yes)
+	try(`{"n":[{"numeric":[">",0,"<=",5]}]}`, `{"n":[{"numeric":[">",0,"<",5]}]}`, Yes)
+	try(`{"n":[{"numeric":[">",0,"<",5]}]}`, `{"n":[{"numeric":[">",0,"<=",5]}]}`, No)
+	try(`{"n":[{"numeric":[">",0]}]}`, `{"n":[{"numeric":[">=",0]}]}`, No)
+	try(`{"n":[{"numeric":[">=",0]}]}`, `{"n":[0,3]}`, Yes)
+	try(`{"a":[{"exists":true}]}`, `{"a":[{"prefix":"x"}]}`, Yes)
+	try(`{"a":[{"exists":true}]}`, `{"a":[{"exists":false}]}`, No)
+	try(`{"a":[{"exists":false}]}`, `{"b":["x"]}`, No)
+	try(`{"a":[{"anything-but":["x"]}]}`, `{"a":["y"]}`, Yes)
+	try(`{"a":[{"anything-but":["x"]}]}`, `{"a":[{"prefix":"y"}]}`, Yes)
+	try(`{"a":[{"anything-but":["x"]}]}`, `{"a":[{"prefix":"x"}]}`, No)
+	try(`{"a":[{"anything-but":["x"]}]}`, `{"a":[{"anything-but":["x","y"]}]}`, Yes)
+	try(`{"a":[{"anything-but":["x","y"]}]}`, `{"a":[{"anything-but":["x"]}]}`, No)
+	try(`{"f":[{"anything-but":["x"]}]}`, `{"f":[{"g":"h"}]}`, No) // {"f":{"g":"h"}}
+	try(`{"a":[{"anything-but":{"prefix":"test-"}}]}`, `{"a":[{"prefix":"prod-"}]}`, Yes)
+	try(`{"a":[{"anything-but":{"prefix":"test-"}}]}`, `{"a":[{"anything-but":{"prefix":"test"}}]}`, Yes)
+	try(`{"a":[{"anything-but":{"prefix":"test"}}]}`, `{"a":[{"anything-but":{"prefix":"test-"}}]}`, No)
+	try(`{"d":{"a":["x"]}}`, `{"d":{"a":["x"],"b":["y"]}}`, Yes)
+	try(`{"$or":[{"a":["x"]},{"b":["y"]}]}`, `{"a":["x"],"c":["z"]}`, Yes)
+	try(`{"$or":[{"a":["x"]},{"b":["y"]}]}`, `{"$or":[{"a":["x"]},{"b":["y"]}]}`, Yes)
+	try(`{"a":["x"]}`, `{"$or":[{"a":["x"]},{"b":["y"]}]}`, No)
+	try(`{"a":[{"wildcard":"x*y"}]}`, `{"a":[{"wildcard":"xz*y"}]}`, Unknown)
+
+	// {"exists":false} matches objects, and a nested pattern sees
+	// a missing parent as {}.
+	try(`{"p":{}}`, `{"p":{"q":[{"exists":false}]}}`, No) // {}
+	try(`{"p":{}}`, `{"p":{"q":["x"]}}`, Yes)
+	try(`{"a":[{"anything-but":["x"]}]}`, `{"a":[{"exists":false}]}`, No)
+	try(`{"a":[{"exists":true}]}`, `{"a":[{"foo":"bar"}]}`, No)
+	try(`{"a":[{"exists":true}]}`, `{"a":[{"anything-but":["x"]}]}`, Yes)
+
+	// There are no rules for "element" and "length".  An empty
+	// array has a length but doesn't exist.
+	try(`{"a":[{"exists":true}]}`, `{"a":[{"length":[">=",0]}]}`, No) // {"a":[]}
+	try(`{"a":[{"anything-but":["x"]}]}`, `{"a":[{"length":[">=",0]}]}`, No)
+	try(`{"a":[{"exists":true}]}`, `{"a":[{"element":{"index":0,"pattern":[{"exists":false}]}}]}`, No)
+	try(`{"a":[{"length":[">=",0]}]}`, `{"a":[{"length":[">=",1]}]}`, Unknown)
+	try(`{"a":[{"length":[">=",1]}]}`, `{"a":[{"element":{"index":0,"pattern":["x"]}}]}`, Unknown)
+	try(`{"a":[{"element":{"index":0,"pattern":["x"]}}]}`, `{"a":["x"]}`, No)
+}
+
+func TestIntersects(t *testing.T) {
+	cfg := &Cfg{
+		Arrays: true,
+	}
+
+	try := func(a, b string, want Answer) {
+		t.Run(a+" "+b, func(t *testing.T) {
+			pa, err := cfg.ParsePattern(P(a))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pb, err := cfg.ParsePattern(P(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Intersects(pa, pb); got != want {
+				t.Fatalf("want %s, got %s", want, got)
+			}
+			if got := Intersects(pb, pa); got != want {
+				t.Fatalf("want %s, got %s (reversed)", want, got)
+			}
+		})
+	}
+
+	try(`{"a":["x"]}`, `{"b":["y"]}`, Yes)
+	try(`{"a":["x"]}`, `{"a":["y"]}`, Yes) // {"a":["x","y"]}
+	try(`{"a":"x"}`, `{"a":"y"}`, No)
+	try(`{"a":"x"}`, `{"a":[{"prefix":"y"}]}`, No)
+	try(`{"a":"xyz"}`, `{"a":[{"prefix":"x"}]}`, Yes)
+	try(`{"a":[{"exists":false}]}`, `{"a":["x"]}`, No)
+	try(`{"a":[{"exists":false}]}`, `{"b":["x"]}`, Yes)
+	try(`{"n":[{"numeric":[">",5]}]}`, `{"n":[{"numeric":["<",3]}]}`, Yes)
+	try(`{"a":[{"anything-but":{"prefix":"t"}}]}`, `{"a":[{"wildcard":"*x*"}]}`, Yes)
+	try(`{"$or":[{"a":"x"},{"a":"y"}]}`, `{"a":"z"}`, No)
+	try(`{"$or":[{"a":"x"},{"a":"y"}]}`, `{"a":"y"}`, Yes)
+
+	// {"k":{"foo":"bar"}} matches both.
+	try(`{"k":[{"exists":false}]}`, `{"k":[{"foo":"bar"}]}`, Yes)
+	try(`{"k":[{"exists":false}]}`, `{"k":[{"exists":true}]}`, No)
+	try(`{"k":[{"exists":false}]}`, `{"k":[{"numeric":[">",0]}]}`, No)
+	try(`{"k":[{"exists":false}]}`, `{"k":[{"anything-but":["x"]}]}`, No)
+	try(`{"k":[]}`, `{"k":["x"]}`, Yes)
+
+	try(`{"k":[{"exists":false}]}`, `{"k":[{"length":[">=",0]}]}`, Yes) // {"k":[]}
+	try(`{"k":[{"exists":true}]}`, `{"k":[{"length":["=",0]}]}`, Unknown)
+	try(`{"k":[{"element":{"index":0,"pattern":["x"]}}]}`, `{"k":[{"element":{"index":0,"pattern":["y"]}}]}`, Unknown)
+}
+
+// TestSubsumesRandom checks that each Yes or No from Subsumes and
+// Intersects agrees with brute force over some messages.
+func TestSubsumesRandom(t *testing.T) {
+	var (
+		r     = rand.New(rand.NewSource(42))
+		paths = []string{"a", "p"}
+		leafs = []string{
+			`"x"`, `1`, `null`, `{"foo":"bar"}`, `{"q":"x"}`,
+			`{"prefix":"x"}`, `{"numeric":[">",0]}`,
+			`{"exists":true}`, `{"exists":false}`,
+			`{"anything-but":["x"]}`,
+			`{"length":[">=",0]}`, `{"length":["=",1]}`,
+			`{"element":{"index":0,"pattern":["x"]}}`,
+			`{"element":{"index":-1,"pattern":[{"exists":false}]}}`,
+		}
+		values = []string{
+			`"x"`, `"y"`, `0`, `1`, `null`, `[]`, `{}`, `[{}]`, `["x",{}]`, `["x"]`, `[{},"y"]`,
+			`{"foo":"bar"}`, `{"q":"x"}`, `{"q":{}}`, `{"q":[]}`, `{"q":1}`,
+		}
+		msgs []interface{}
+		cfg  = &Cfg{
+			Arrays: true,
+		}
+	)
+
+	constraints := func() string {
+		acc := "["
+		for j := 0; j <= r.Intn(2); j++ {
+			if 0 < j {
+				acc += ","
+			}
+			acc += leafs[r.Intn(len(leafs))]
+		}
+		return acc + "]"
+	}
+
+	pattern := func() string {
+		acc := "{"
+		for i, p := range r.Perm(len(paths))[:1+r.Intn(len(paths))] {
+			if 0 < i {
+				acc += ","
+			}
+			acc += `"` + paths[p] + `":`
+			switch {
+			case paths[p] == "a":
+				acc += constraints()
+			case r.Intn(4) == 0:
+				acc += `{}`
+			default:
+				acc += `{"q":` + constraints() + `}`
+			}
+		}
+		return acc + "}"
+	}
+
+	msgs = append(msgs, P(`{}`))
+	for _, a := range values {
+		msgs = append(msgs, P(`{"a":`+a+`}`), P(`{"p":`+a+`}`))
+		for _, p := range values {
+			msgs = append(msgs, P(`{"a":`+a+`,"p":`+p+`}`))
+		}
+	}
+
+	for i := 0; i < 500; i++ {
+		ja, jb := pattern(), pattern()
+		a, err := cfg.ParsePattern(P(ja))
+		if err != nil {
+			t.Fatal(ja, err)
+		}
+		b, err := cfg.ParsePattern(P(jb))
+		if err != nil {
+			t.Fatal(jb, err)
+		}
+		subsumes, intersects := Subsumes(a, b), Intersects(a, b)
+		for _, msg := range msgs {
+			ma, mb := matches(a, msg), matches(b, msg)
+			if subsumes == Yes && mb && !ma {
+				t.Fatalf("%s subsumes %s but not for %s", ja, jb, JSON(msg))
+			}
+			if intersects == No && ma && mb {
+				t.Fatalf("%s and %s don't intersect but both match %s", ja, jb, JSON(msg))
+			}
+		}
+	}
+}
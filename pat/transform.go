@@ -0,0 +1,430 @@
+package pat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Path is a parsed JSON path like "$.detail.items[0].id".  Each step
+// is either a string (an object property) or an int (an array
+// index).  The empty Path is the whole value.
+type Path []interface{}
+
+// ParsePath parses a JSON path in the subset that EventBridge's
+// InputPathsMap accepts: a leading "$", ".name" steps, "[n]" indexes,
+// and ['name'] (or ["name"]) steps for names that aren't simple.
+//
+// The leading "$" is optional, so "detail.orderId" is the same as
+// "$.detail.orderId" and "['a.b']" is the same as "$['a.b']".
+func ParsePath(s string) (Path, error) {
+	rest := s
+	switch {
+	case rest == "$":
+		return Path{}, nil
+	case strings.HasPrefix(rest, "$.") || strings.HasPrefix(rest, "$["):
+		rest = rest[1:]
+	case rest == "":
+		return nil, fmt.Errorf("empty path")
+	case rest[0] == '[':
+	default:
+		rest = "." + rest
+	}
+
+	var p Path
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			n := strings.IndexAny(rest, ".[")
+			if n < 0 {
+				n = len(rest)
+			}
+			if n == 0 {
+				return nil, fmt.Errorf("bad path '%s': empty name", s)
+			}
+			p = append(p, rest[:n])
+			rest = rest[n:]
+		case '[':
+			if 1 < len(rest) && (rest[1] == '\'' || rest[1] == '"') {
+				k, n, err := quotedStep(rest)
+				if err != nil {
+					return nil, fmt.Errorf("bad path '%s': %s", s, err)
+				}
+				p = append(p, k)
+				rest = rest[n:]
+				break
+			}
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("bad path '%s': missing ']'", s)
+			}
+			inner := rest[1:end]
+			i, err := strconv.Atoi(inner)
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("bad path '%s': bad index '%s'", s, inner)
+			}
+			p = append(p, i)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("bad path '%s'", s)
+		}
+	}
+	return p, nil
+}
+
+// quotedStep parses a step like ['name'] at the start of the given
+// string.  In the quoted name, a backslash escapes the next
+// character.  The int is the length of the step.
+func quotedStep(s string) (string, int, error) {
+	var (
+		q   = s[1]
+		acc strings.Builder
+	)
+	for i := 2; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", 0, fmt.Errorf("unterminated name")
+			}
+			acc.WriteByte(s[i])
+		case q:
+			if i+1 == len(s) || s[i+1] != ']' {
+				return "", 0, fmt.Errorf("missing ']'")
+			}
+			return acc.String(), i + 2, nil
+		default:
+			acc.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated name")
+}
+
+// String renders the Path in the "$.a.b[0]" syntax, which ParsePath
+// parses back into the same Path.  A name that isn't simple is
+// quoted as ['name'] with backslash escapes.
+func (p Path) String() string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, step := range p {
+		switch vv := step.(type) {
+		case int:
+			b.WriteString("[" + strconv.Itoa(vv) + "]")
+		case string:
+			if simpleName(vv) {
+				b.WriteString("." + vv)
+			} else {
+				b.WriteString(quoteName(vv))
+			}
+		}
+	}
+	return b.String()
+}
+
+// simpleName reports whether the name can be written as ".name" in a
+// path.
+func simpleName(k string) bool {
+	return k != "" && !strings.ContainsAny(k, ".[]'\"\\")
+}
+
+// quoteName renders the name as a ['name'] step with backslash
+// escapes.
+func quoteName(k string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "['" + r.Replace(k) + "']"
+}
+
+// Get returns the value at the Path in the given generic
+// (JSON-decoded) value.  The boolean is false if there's no such
+// value.
+func (p Path) Get(x interface{}) (interface{}, bool) {
+	for _, step := range p {
+		switch vv := step.(type) {
+		case string:
+			m, is := x.(map[string]interface{})
+			if !is {
+				return nil, false
+			}
+			if x, is = m[vv]; !is {
+				return nil, false
+			}
+		case int:
+			xs, is := x.([]interface{})
+			if !is || len(xs) <= vv {
+				return nil, false
+			}
+			x = xs[vv]
+		}
+	}
+	return x, true
+}
+
+// selectPath adds the given Path to the selection.  Selections don't
+// know about arrays, so the value at the first index is decoded
+// whole.
+func (p *paths) selectPath(path Path) {
+	at := p
+	for _, step := range path {
+		k, is := step.(string)
+		if !is {
+			break
+		}
+		child, have := at.children[k]
+		if !have {
+			child = &paths{
+				children: make(map[string]*paths),
+			}
+			at.children[k] = child
+		}
+		at = child
+	}
+	at.all = true
+}
+
+// Transformer reshapes an event like an EventBridge input
+// transformer.  Values are extracted by the paths in an
+// InputPathsMap, and then they're rendered into an InputTemplate.
+//
+// In a template, "<name>" refers to the value of the path with that
+// name.  Inside a JSON string, a string value is inserted as its
+// escaped contents, and other values are inserted as their escaped
+// JSON representations.  Elsewhere a value is inserted as JSON.  A
+// missing value is "" inside a string and null elsewhere.  A
+// template that doesn't start with '{', '[', or '"' is plain text:
+// values are inserted without quotes or escaping, and the output
+// needn't be JSON.
+//
+// Without a template, the output is an object with each name's
+// value.  Missing values are omitted.
+//
+// A "<name>" that isn't in the InputPathsMap is left alone.
+type Transformer struct {
+	paths  map[string]Path
+	chunks []chunk
+	text   bool
+	sel    *paths
+}
+
+// chunk is a piece of a template: either literal text or a
+// reference to a named value.
+type chunk struct {
+	lit      string
+	name     string
+	inString bool
+}
+
+// NewTransformer makes a Transformer with the given InputPathsMap and
+// InputTemplate.  An empty (or blank) template gives the object of the
+// extracted values.
+func NewTransformer(inputPaths map[string]string, template string) (*Transformer, error) {
+	t := &Transformer{
+		paths: make(map[string]Path, len(inputPaths)),
+		sel: &paths{
+			children: make(map[string]*paths),
+		},
+	}
+	for name, s := range inputPaths {
+		if !validName(name) {
+			return nil, fmt.Errorf("bad input path name '%s'", name)
+		}
+		p, err := ParsePath(s)
+		if err != nil {
+			return nil, err
+		}
+		t.paths[name] = p
+		t.sel.selectPath(p)
+	}
+
+	if strings.TrimSpace(template) == "" {
+		return t, nil
+	}
+
+	switch strings.TrimSpace(template)[:1] {
+	case "{", "[", "\"":
+	default:
+		t.text = true
+	}
+	t.chunks = t.parseTemplate(template)
+
+	if !t.text {
+		// Check that the template is JSON no matter what the
+		// values are.
+		js, err := t.render(map[string]interface{}{})
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid(js) {
+			return nil, fmt.Errorf("template isn't JSON: %s", template)
+		}
+	}
+
+	return t, nil
+}
+
+// NewProjection makes a Transformer without a template that
+// extracts the values at the given paths.  The output is an object
+// with each value at the path as it was given, so
+// ["detail.orderId","time"] could give
+//
+//	{"detail.orderId":"o-1","time":"2022-02-04T01:02:03Z"}
+func NewProjection(ps []string) (*Transformer, error) {
+	t := &Transformer{
+		paths: make(map[string]Path, len(ps)),
+		sel: &paths{
+			children: make(map[string]*paths),
+		},
+	}
+	for _, s := range ps {
+		p, err := ParsePath(s)
+		if err != nil {
+			return nil, err
+		}
+		t.paths[s] = p
+		t.sel.selectPath(p)
+	}
+	return t, nil
+}
+
+// Text reports whether the Transformer's template is plain text, in
+// which case its output isn't JSON.
+func (t *Transformer) Text() bool {
+	return t.text
+}
+
+// validName reports whether the given string can be a name in an
+// InputPathsMap (and therefore in a template).
+func validName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case r == '_', r == '-', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseTemplate splits the template into chunks, keeping track of
+// whether each reference is inside a JSON string.
+func (t *Transformer) parseTemplate(template string) []chunk {
+	var (
+		acc      []chunk
+		lit      strings.Builder
+		inString bool
+	)
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		switch {
+		case c == '\\' && inString && i+1 < len(template):
+			lit.WriteByte(c)
+			i++
+			lit.WriteByte(template[i])
+			continue
+		case c == '"' && !t.text:
+			inString = !inString
+		case c == '<':
+			if end := strings.IndexByte(template[i:], '>'); 0 < end {
+				name := template[i+1 : i+end]
+				if _, have := t.paths[name]; have {
+					acc = append(acc, chunk{lit: lit.String()}, chunk{
+						name:     name,
+						inString: inString,
+					})
+					lit.Reset()
+					i += end
+					continue
+				}
+			}
+		}
+		lit.WriteByte(c)
+	}
+	if 0 < lit.Len() {
+		acc = append(acc, chunk{lit: lit.String()})
+	}
+	return acc
+}
+
+// Transform extracts values from the given Go value and renders
+// them.  The value is walked as in MatchesValue, so only the parts
+// at the input paths are converted.
+func (t *Transformer) Transform(v interface{}) ([]byte, error) {
+	x, err := generic(reflect.ValueOf(v), t.sel)
+	if err != nil {
+		return nil, err
+	}
+	return t.TransformGeneric(x)
+}
+
+// TransformJSON is Transform for a JSON document, which is only
+// decoded as much as the input paths require.
+func (t *Transformer) TransformJSON(js []byte) ([]byte, error) {
+	x, err := decodeSelection(js, t.sel)
+	if err != nil {
+		return nil, err
+	}
+	return t.TransformGeneric(x)
+}
+
+// TransformGeneric is Transform for a generic (JSON-decoded) value.
+func (t *Transformer) TransformGeneric(x interface{}) ([]byte, error) {
+	vals := make(map[string]interface{}, len(t.paths))
+	for name, p := range t.paths {
+		if y, have := p.Get(x); have {
+			vals[name] = y
+		}
+	}
+	if t.chunks == nil {
+		return marshal(vals)
+	}
+	return t.render(vals)
+}
+
+func (t *Transformer) render(vals map[string]interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	for _, c := range t.chunks {
+		if c.name == "" {
+			buf.WriteString(c.lit)
+			continue
+		}
+		x, have := vals[c.name]
+		if !have {
+			if !c.inString && !t.text {
+				buf.WriteString("null")
+			}
+			continue
+		}
+		if s, is := x.(string); is && (c.inString || t.text) {
+			if t.text {
+				buf.WriteString(s)
+			} else {
+				writeEscaped(buf, s)
+			}
+			continue
+		}
+		js, err := marshal(x)
+		if err != nil {
+			return nil, err
+		}
+		if c.inString {
+			writeEscaped(buf, string(js))
+		} else {
+			buf.Write(js)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// writeEscaped writes the given string as the contents of a JSON
+// string (without the quotes).
+func writeEscaped(buf *bytes.Buffer, s string) {
+	js, _ := marshal(s)
+	buf.Write(js[1 : len(js)-1])
+}
@@ -0,0 +1,219 @@
+package pat
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	for _, c := range []struct {
+		In   string
+		Want Path
+		Err  bool
+	}{
+		{In: "$", Want: Path{}},
+		{In: "$.detail.orderId", Want: Path{"detail", "orderId"}},
+		{In: "detail.orderId", Want: Path{"detail", "orderId"}},
+		{In: "$.detail.items[1].sku", Want: Path{"detail", "items", 1, "sku"}},
+		{In: "$['detail-type']", Want: Path{"detail-type"}},
+		{In: `$.a["b.c"]`, Want: Path{"a", "b.c"}},
+		{In: `$['it\'s']["a\"b"]`, Want: Path{"it's", `a"b`}},
+		{In: `$['a]b']['c\\d']`, Want: Path{"a]b", `c\d`}},
+		{In: "['a.b'].c", Want: Path{"a.b", "c"}},
+		{In: "", Err: true},
+		{In: "$['a", Err: true},
+		{In: "$['a'b]", Err: true},
+		{In: "$.", Err: true},
+		{In: "$..a", Err: true},
+		{In: "$.a[", Err: true},
+		{In: "$.a[-1]", Err: true},
+		{In: "$.a[x]", Err: true},
+	} {
+		t.Run(c.In, func(t *testing.T) {
+			p, err := ParsePath(c.In)
+			if c.Err {
+				if err == nil {
+					t.Fatalf("expected an error but got %#v", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p, c.Want) {
+				t.Fatalf("%#v != %#v", p, c.Want)
+			}
+			q, err := ParsePath(p.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p, q) {
+				t.Fatalf("%s: %#v != %#v", p, p, q)
+			}
+		})
+	}
+}
+
+func TestPathString(t *testing.T) {
+	for _, p := range []Path{
+		{},
+		{"a", 0, "b"},
+		{""},
+		{"a.b", "c[0]", "d]", "e[", "it's", `say "hi"`, `back\slash`, `\'`, "$"},
+		{"a b", 3, "€"},
+	} {
+		s := p.String()
+		q, err := ParsePath(s)
+		if err != nil {
+			t.Fatalf("%s: %s", s, err)
+		}
+		if !reflect.DeepEqual(p, q) {
+			t.Fatalf("%s: %#v != %#v", s, q, p)
+		}
+	}
+}
+
+func TestTransformer(t *testing.T) {
+	event := `{
+  "detail-type": "Order Placed",
+  "time": "2022-02-04T01:02:03Z",
+  "detail": {
+    "orderId": "o-1",
+    "note": "say \"hi\" <b>",
+    "total": 12.50,
+    "items": [{"sku": "taco"}, {"sku": "burrito"}],
+    "rush": true
+  }
+}`
+	inputPaths := map[string]string{
+		"id":    "$.detail.orderId",
+		"time":  "$.time",
+		"type":  "$['detail-type']",
+		"note":  "$.detail.note",
+		"total": "$.detail.total",
+		"sku":   "$.detail.items[1].sku",
+		"items": "$.detail.items",
+		"rush":  "$.detail.rush",
+		"gone":  "$.detail.missing",
+	}
+
+	for _, c := range []struct {
+		Name     string
+		Template string
+		Want     string
+	}{
+		{
+			Name: "none",
+			Want: `{"id":"o-1","items":[{"sku":"taco"},{"sku":"burrito"}],"note":"say \"hi\" <b>","rush":true,"sku":"burrito","time":"2022-02-04T01:02:03Z","total":12.50,"type":"Order Placed"}`,
+		},
+		{
+			Name:     "object",
+			Template: `{"orderId": <id>, "at": <time>, "total": <total>, "gone": <gone>}`,
+			Want:     `{"orderId": "o-1", "at": "2022-02-04T01:02:03Z", "total": 12.50, "gone": null}`,
+		},
+		{
+			Name:     "in string",
+			Template: `{"msg": "<type> <id>: <note> (<total>, <rush>, <gone>)"}`,
+			Want:     `{"msg": "Order Placed o-1: say \"hi\" <b> (12.50, true, )"}`,
+		},
+		{
+			Name:     "structure in string",
+			Template: `"items: <items>"`,
+			Want:     `"items: [{\"sku\":\"taco\"},{\"sku\":\"burrito\"}]"`,
+		},
+		{
+			Name:     "escaped quote",
+			Template: `{"q": "\"<id>\"", "id": <id>}`,
+			Want:     `{"q": "\"o-1\"", "id": "o-1"}`,
+		},
+		{
+			Name:     "unknown",
+			Template: `{"html": "<p><id></p>"}`,
+			Want:     `{"html": "<p>o-1</p>"}`,
+		},
+		{
+			Name:     "text",
+			Template: `Order <id> for <sku> is "<note>"`,
+			Want:     `Order o-1 for burrito is "say "hi" <b>"`,
+		},
+	} {
+		t.Run(c.Name, func(t *testing.T) {
+			tr, err := NewTransformer(inputPaths, c.Template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tr.TransformJSON([]byte(event))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != c.Want {
+				t.Fatalf("\n%s\n!=\n%s", got, c.Want)
+			}
+			if !tr.text && !json.Valid(got) {
+				t.Fatalf("not JSON: %s", got)
+			}
+
+			var x interface{}
+			if err := json.Unmarshal([]byte(event), &x); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = tr.TransformGeneric(x); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestTransformerValue(t *testing.T) {
+	tr, err := NewTransformer(map[string]string{
+		"id":   "$.id",
+		"tag":  "$.tags[1]",
+		"deep": "$.any.deep[0]",
+		"x":    "$.raw.x[1].y",
+		"n":    "$.number",
+	}, `[<id>, <tag>, <deep>, <x>, <n>]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := tr.Transform(orders()[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `["o1", "b", 1, "z", 12345678901234567890]`; string(got) != want {
+		t.Fatalf("%s != %s", got, want)
+	}
+}
+
+func TestTransformerErrors(t *testing.T) {
+	for _, c := range []struct {
+		Name       string
+		InputPaths map[string]string
+		Template   string
+	}{
+		{
+			Name:       "bad path",
+			InputPaths: map[string]string{"a": "$.a[x]"},
+		},
+		{
+			Name:       "bad name",
+			InputPaths: map[string]string{"a b": "$.a"},
+		},
+		{
+			Name:       "not JSON",
+			InputPaths: map[string]string{"a": "$.a"},
+			Template:   `{"a": <a>`,
+		},
+		{
+			Name:       "unknown reference",
+			InputPaths: map[string]string{"a": "$.a"},
+			Template:   `{"a": <b>}`,
+		},
+	} {
+		t.Run(c.Name, func(t *testing.T) {
+			if _, err := NewTransformer(c.InputPaths, c.Template); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -3,8 +3,21 @@ package pat
 import (
 	"bytes"
 	"encoding/json"
+	"sort"
 )
 
+// sortedKeys returns the keys of the given map in order.  Parsing
+// walks objects in this order so that the same pattern always gives
+// the same error.
+func sortedKeys(m map[string]interface{}) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
 func JSON(x interface{}) string {
 	buffer := &bytes.Buffer{}
 	encoder := json.NewEncoder(buffer)
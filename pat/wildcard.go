@@ -0,0 +1,92 @@
+package pat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Wildcard matches strings against a glob where "*" matches zero or
+// more characters.
+//
+// A literal "*" is written as "\*", and a literal "\" is written as
+// "\\".  As with EventBridge, consecutive wildcards are not allowed.
+//
+// Use NewWildcard to make one.
+type Wildcard struct {
+	Value string
+
+	// segments are the literal pieces between the wildcards, so
+	// there's always one more segment than there are wildcards.
+	segments []string
+}
+
+// NewWildcard compiles the given glob.
+func NewWildcard(s string) (*Wildcard, error) {
+	var (
+		segments = make([]string, 0, 2)
+		acc      = &strings.Builder{}
+		star     = false
+	)
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; b {
+		case '*':
+			if star {
+				return nil, fmt.Errorf("consecutive wildcards in '%s'", s)
+			}
+			star = true
+			segments = append(segments, acc.String())
+			acc.Reset()
+			continue
+		case '\\':
+			i++
+			if i == len(s) || (s[i] != '*' && s[i] != '\\') {
+				return nil, fmt.Errorf("bad escape in wildcard '%s'", s)
+			}
+			acc.WriteByte(s[i])
+		default:
+			acc.WriteByte(b)
+		}
+		star = false
+	}
+	segments = append(segments, acc.String())
+
+	return &Wildcard{
+		Value:    s,
+		segments: segments,
+	}, nil
+}
+
+func (c *Wildcard) Matches(x interface{}) (bool, error) {
+	s, is := x.(string)
+	if !is {
+		return false, nil
+	}
+
+	segs := c.segments
+	switch len(segs) {
+	case 0:
+		return false, fmt.Errorf("uncompiled wildcard '%s'", c.Value)
+	case 1:
+		return s == segs[0], nil
+	}
+
+	first, last := segs[0], segs[len(segs)-1]
+	if !strings.HasPrefix(s, first) {
+		return false, nil
+	}
+	s = s[len(first):]
+	if len(s) < len(last) || !strings.HasSuffix(s, last) {
+		return false, nil
+	}
+	s = s[:len(s)-len(last)]
+
+	for _, seg := range segs[1 : len(segs)-1] {
+		i := strings.Index(s, seg)
+		if i < 0 {
+			return false, nil
+		}
+		s = s[i+len(seg):]
+	}
+
+	return true, nil
+}
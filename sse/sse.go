@@ -1,8 +1,10 @@
 package sse
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -23,17 +25,26 @@ type Cfg struct {
 	// MaxBody is the maximum number of bytes to read from the
 	// client.
 	//
-	// The body contains the filter (if any), so it's probably
-	// important to limit what's read pretty aggressively.
+	// The body contains the filter and projection (if any), so
+	// it's probably important to limit what's read pretty
+	// aggressively.
 	MaxBody int64
 
 	// Logging turns on some basic logging.
 	Logging bool
+
+	// Pat is the configuration for parsing filters, which come
+	// from untrusted clients.  Its limits keep a client from
+	// submitting a filter that would slow matching for everyone.
+	//
+	// If nil, pat.DefaultCfg is used.
+	Pat *pat.Cfg
 }
 
 var DefaultCfg = &Cfg{
 	SessionLimit: 10000,
 	MaxBody:      4 * 1024,
+	Pat:          pat.DefaultCfg,
 }
 
 type SSE struct {
@@ -52,6 +63,34 @@ func NewSSE(bus *bus.Bus) *SSE {
 	return DefaultCfg.New(bus)
 }
 
+// parsePattern parses a pattern from a client.
+//
+// A pattern that exceeds one of the pat.Cfg's limits results in a
+// *pat.LimitError.
+func (s *SSE) parsePattern(x interface{}) (pat.Constraint, error) {
+	cfg := s.Cfg.Pat
+	if cfg == nil {
+		cfg = pat.DefaultCfg
+	}
+	return cfg.ParsePattern(x)
+}
+
+// badPattern reports a pattern that failed to parse.  A
+// *pat.ParseError is returned as JSON:
+//
+//	{"error":{"code":"range","path":"price[0].numeric","value":[">",10,"<",5],"message":"..."}}
+func badPattern(w http.ResponseWriter, what string, js []byte, err error) {
+	var pe *pat.ParseError
+	if errors.As(err, &pe) {
+		w.Header().Set("Content-Type", "application/json")
+		punt(w, http.StatusBadRequest, "%s", pat.JSON(map[string]interface{}{
+			"error": pe,
+		}))
+		return
+	}
+	punt(w, http.StatusBadRequest, "bad %s %s: (%s)\n", what, js, err)
+}
+
 func (s *SSE) logf(format string, args ...interface{}) {
 	if !s.Cfg.Logging {
 		return
@@ -64,6 +103,109 @@ func punt(w http.ResponseWriter, status int, format string, args ...interface{})
 	fmt.Fprintf(w, format, args...)
 }
 
+// decode is json.Unmarshal except that numbers are decoded as
+// json.Numbers so that they keep their precision.
+func decode(js []byte, x interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(js))
+	d.UseNumber()
+	if err := d.Decode(x); err != nil {
+		return err
+	}
+	if d.More() {
+		return fmt.Errorf("trailing data after JSON")
+	}
+	return nil
+}
+
+// envelope splits a request body into its filter and its projection
+// (either of which can be nil).
+//
+// The body is either just a filter or an object like
+//
+//	{"filter":{"payload":{"want":["tacos"]}},"projection":["want"]}
+//
+// A filter is matched against a bus.Msg, whose properties are "type",
+// "payload", and "id", so a body with a "filter" or "projection"
+// property can't be just a filter.
+func envelope(x interface{}) (filter, projection interface{}, err error) {
+	m, is := x.(map[string]interface{})
+	if !is {
+		return x, nil, nil
+	}
+	_, hasFilter := m["filter"]
+	_, hasProjection := m["projection"]
+	if !hasFilter && !hasProjection {
+		return x, nil, nil
+	}
+	for k := range m {
+		if k != "filter" && k != "projection" {
+			return nil, nil, fmt.Errorf("unknown property '%s'", k)
+		}
+	}
+	return m["filter"], m["projection"], nil
+}
+
+// parseProjection makes a pat.Transformer from a projection in a
+// request.  A projection is either an array of paths (see
+// pat.NewProjection) or an object like
+//
+//	{"paths":{"id":"$.detail.orderId"},"template":"{\"order\":<id>}"}
+//
+// (see pat.NewTransformer).  Paths are relative to a message's
+// payload.
+func parseProjection(x interface{}) (*pat.Transformer, error) {
+	switch vv := x.(type) {
+	case []interface{}:
+		if len(vv) == 0 {
+			return nil, fmt.Errorf("no paths")
+		}
+		ps := make([]string, len(vv))
+		for i, y := range vv {
+			s, is := y.(string)
+			if !is {
+				return nil, fmt.Errorf("bad path '%#v'", y)
+			}
+			ps[i] = s
+		}
+		return pat.NewProjection(ps)
+	case map[string]interface{}:
+		var (
+			paths    = make(map[string]string)
+			template string
+		)
+		for k, v := range vv {
+			switch k {
+			case "paths":
+				m, is := v.(map[string]interface{})
+				if !is || len(m) == 0 {
+					return nil, fmt.Errorf("bad paths '%#v'", v)
+				}
+				for name, p := range m {
+					s, is := p.(string)
+					if !is {
+						return nil, fmt.Errorf("bad path '%#v'", p)
+					}
+					paths[name] = s
+				}
+			case "template":
+				s, is := v.(string)
+				if !is {
+					return nil, fmt.Errorf("bad template '%#v'", v)
+				}
+				template = s
+			default:
+				return nil, fmt.Errorf("unknown property '%s'", k)
+			}
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("no paths")
+		}
+		return pat.NewTransformer(paths, template)
+	default:
+		return nil, fmt.Errorf("bad projection '%#v'", x)
+	}
+}
+
 func (s *SSE) Handle(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	s.logf("SSE.Handle")
 
@@ -102,19 +244,35 @@ func (s *SSE) Handle(ctx context.Context, w http.ResponseWriter, r *http.Request
 		punt(w, http.StatusBadRequest, "failed to read filter: %s\n", err)
 		return nil
 	}
-	var filter pat.Constraint = pat.Pass
+	var (
+		filter     pat.Constraint = pat.Pass
+		projection *pat.Transformer
+	)
 	if 0 < len(js) {
 		var x interface{}
-		if err = json.Unmarshal(js, &x); err != nil {
+		if err = decode(js, &x); err != nil {
 			punt(w, http.StatusBadRequest, "bad filter %s: (%s)\n", js, err)
 			return nil
 		}
-		p, err := pat.DefaultCfg.ParsePattern(x)
+		x, y, err := envelope(x)
 		if err != nil {
-			punt(w, http.StatusBadRequest, "bad filter %s: (%s)\n", js, err)
+			punt(w, http.StatusBadRequest, "bad request %s: (%s)\n", js, err)
 			return nil
 		}
-		filter = p
+		if x != nil {
+			p, err := s.parsePattern(x)
+			if err != nil {
+				badPattern(w, "filter", js, err)
+				return nil
+			}
+			filter = p
+		}
+		if y != nil {
+			if projection, err = parseProjection(y); err != nil {
+				punt(w, http.StatusBadRequest, "bad projection %s: (%s)\n", js, err)
+				return nil
+			}
+		}
 	}
 
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -128,9 +286,10 @@ func (s *SSE) Handle(ctx context.Context, w http.ResponseWriter, r *http.Request
 	c := &bus.Consumer{
 		Outgoing: make(chan []bus.Msg),
 		Query: &bus.Query{
-			Replay: replay,
-			Filter: filter,
-			Limit:  limit,
+			Replay:     replay,
+			Filter:     filter,
+			Limit:      limit,
+			Projection: projection,
 		},
 	}
 
@@ -194,3 +353,38 @@ LOOP:
 
 	return nil
 }
+
+// ExplainRequest is the body of a request to HandleExplain.
+type ExplainRequest struct {
+	Pattern interface{} `json:"pattern"`
+	Event   interface{} `json:"event"`
+}
+
+// HandleExplain is a debugging endpoint that responds with the
+// pat.Explanation of why the event in the request body did or did not
+// match the pattern in the request body.
+//
+// See ExplainRequest.
+func (s *SSE) HandleExplain(w http.ResponseWriter, r *http.Request) {
+	s.logf("SSE.HandleExplain")
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.MaxBody)
+	js, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		punt(w, http.StatusBadRequest, "failed to read request: %s\n", err)
+		return
+	}
+	var req ExplainRequest
+	if err = decode(js, &req); err != nil {
+		punt(w, http.StatusBadRequest, "bad request %s: (%s)\n", js, err)
+		return
+	}
+	p, err := s.parsePattern(req.Pattern)
+	if err != nil {
+		badPattern(w, "pattern", []byte(pat.JSON(req.Pattern)), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, pat.JSON(pat.Explain(p, req.Event)))
+}
@@ -2,15 +2,18 @@ package sse
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/jsmorph/evpat/bus"
+	"github.com/jsmorph/evpat/pat"
 )
 
 func TestBasic(t *testing.T) {
@@ -66,3 +69,141 @@ func TestBasic(t *testing.T) {
 		time.Sleep(time.Second)
 	}
 }
+
+func TestExplain(t *testing.T) {
+	s := NewSSE(bus.NewBus())
+
+	try := func(body string, status int, matched bool) {
+		t.Run(body, func(t *testing.T) {
+			var (
+				r = httptest.NewRequest("POST", "/debug/explain", strings.NewReader(body))
+				w = httptest.NewRecorder()
+			)
+			s.HandleExplain(w, r)
+			if w.Code != status {
+				t.Fatal(w.Code, w.Body.String())
+			}
+			if status != http.StatusOK {
+				return
+			}
+			var e pat.Explanation
+			if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Matched != matched {
+				t.Fatal(w.Body.String())
+			}
+		})
+	}
+
+	try(`{"pattern":{"want":["tacos"]},"event":{"want":"tacos"}}`, http.StatusOK, true)
+	try(`{"pattern":{"want":["tacos"]},"event":{"want":"queso"}}`, http.StatusOK, false)
+	try(`{"pattern":{"want":[{"numeric":7}]},"event":{}}`, http.StatusBadRequest, false)
+	try(`{"pattern":`, http.StatusBadRequest, false)
+}
+
+func TestFilterLimits(t *testing.T) {
+	cfg := *DefaultCfg
+	cfg.Pat = &pat.Cfg{
+		MaxDepth: 3,
+	}
+	s := cfg.New(bus.NewBus())
+
+	filter := `{"a":{"b":{"c":["x"]}}}`
+	r := httptest.NewRequest("POST", "/", strings.NewReader(filter))
+	w := httptest.NewRecorder()
+	s.Handle(context.Background(), w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	var res struct {
+		Error struct {
+			Code    string `json:"code"`
+			Path    string `json:"path"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	if res.Error.Code != pat.CodeLimit || res.Error.Path != "a.b.c" ||
+		!strings.Contains(res.Error.Message, "MaxDepth") {
+		t.Fatal(w.Body.String())
+	}
+}
+
+func TestProjection(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		b           = bus.NewBus()
+		cfg         = *DefaultCfg
+	)
+	defer cancel()
+	cfg.SessionLimit = 1
+	s := cfg.New(b)
+
+	go b.Run(ctx)
+
+	body := `{"filter":{"type":["order"]},"projection":{"paths":{"id":"$.detail.orderId"},"template":"{\"order\":<id>}"}}`
+	var (
+		r    = httptest.NewRequest("POST", "/", strings.NewReader(body))
+		w    = httptest.NewRecorder()
+		done = make(chan error)
+	)
+	go func() {
+		done <- s.Handle(ctx, w, r)
+	}()
+
+	msgs := []bus.Msg{
+		{Type: "order", Id: "1", Payload: map[string]interface{}{
+			"detail": map[string]interface{}{
+				"orderId": "o-1",
+				"items":   []interface{}{"taco", "burrito"},
+			},
+		}},
+	}
+LOOP:
+	for {
+		// The consumer might not be registered yet.
+		select {
+		case <-ctx.Done():
+			t.Fatal("timeout")
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			break LOOP
+		case b.Incoming <- msgs:
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	want := "event: order\nid: 1\ndata: {\"type\":\"order\",\"payload\":{\"order\":\"o-1\"},\"id\":\"1\"}\n\n"
+	if got := w.Body.String(); got != want {
+		t.Fatalf("%q != %q", got, want)
+	}
+}
+
+func TestBadProjection(t *testing.T) {
+	s := NewSSE(bus.NewBus())
+
+	for _, body := range []string{
+		`{"projection":[]}`,
+		`{"projection":[1]}`,
+		`{"projection":["a[x]"]}`,
+		`{"projection":{"template":"<a>"}}`,
+		`{"projection":{"paths":{"a":"$.a"},"template":"{<a>"}}`,
+		`{"projection":{"paths":{"a":"$.a"},"junk":1}}`,
+		`{"projection":"a"}`,
+		`{"filter":{},"projection":["a"],"junk":1}`,
+	} {
+		t.Run(body, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			s.Handle(context.Background(), w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Fatal(w.Code, w.Body.String())
+			}
+		})
+	}
+}